@@ -19,11 +19,17 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/rafaeljusto/crawler"
+	"io"
+	"net/http"
 	"os"
 	"runtime"
+	"strings"
 )
 
 // List of possible return codes of the program
@@ -31,24 +37,194 @@ const (
 	NoError = iota
 	ErrInputParameters
 	ErrCrawlerExecution
+	ErrBrokenLinks
+	ErrOutput
 )
 
+// headerFlag stores the HTTP headers informed many times in the command line, in the format
+// "Name: value"
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var headers []string
+	for key, values := range h {
+		for _, value := range values {
+			headers = append(headers, key+": "+value)
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	index := strings.Index(value, ":")
+	if index <= 0 {
+		return fmt.Errorf("invalid header %q, expected format is \"Name: value\"", value)
+	}
+
+	http.Header(h).Add(strings.TrimSpace(value[:index]), strings.TrimSpace(value[index+1:]))
+	return nil
+}
+
+// urlsFlag stores the start URLs informed many times in the command line
+type urlsFlag []string
+
+func (u *urlsFlag) String() string {
+	return strings.Join(*u, ", ")
+}
+
+func (u *urlsFlag) Set(value string) error {
+	*u = append(*u, value)
+	return nil
+}
+
 // main will control the flow of all go routines that retrieve each crawler
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	var url string
-	flag.StringVar(&url, "url", "", "URL to build the site map")
-	flag.StringVar(&url, "u", "", "URL to build the site map")
+	var urls urlsFlag
+	var seedsFile, subdomains, pathPrefix, format, output, inlinks string
+	var compress, canonicalReport, redirectsReport bool
+	var cluster, maxRedirects int
+	var limits crawler.HostLimits
+	retryPolicy := crawler.DefaultRetryPolicy
+	clientConfig := crawler.HTTPClientConfig{
+		Timeout: crawler.DefaultHTTPTimeout,
+	}
+	httpFetcher := crawler.HTTPFetcher{
+		Header: make(http.Header),
+	}
+	options := crawler.DefaultCrawlOptions()
+
+	flag.Var(&urls, "url", "URL to build the site map (can be repeated)")
+	flag.Var(&urls, "u", "URL to build the site map (can be repeated)")
+	flag.StringVar(&seedsFile, "seeds", "",
+		"File with more URLs to build the site map, one per line (# starts a comment)")
+	flag.IntVar(&options.MaxConcurrency, "concurrency", options.MaxConcurrency,
+		"Maximum number of pages fetched at the same time")
+	flag.IntVar(&options.MaxDepth, "depth", 0,
+		"Maximum number of links followed from the URL (0 for no limit)")
+	flag.IntVar(&options.MaxPages, "pages", 0, "Maximum number of pages crawled (0 for no limit)")
+	flag.DurationVar(&options.Timeout, "timeout", 0, "Maximum duration of the crawl (0 for no limit)")
+	flag.Int64Var(&options.MaxBodySize, "max-body-size", options.MaxBodySize,
+		"Maximum size in bytes of a page analyzed (0 for no limit)")
+	flag.Float64Var(&limits.RequestsPerSecond, "rate", 0,
+		"Maximum number of requests per second to each host (0 for no limit)")
+	flag.IntVar(&limits.MaxConcurrency, "host-concurrency", 10,
+		"Maximum number of simultaneous requests to each host (0 for no limit)")
+	flag.DurationVar(&limits.Jitter, "jitter", 0,
+		"Maximum random delay added before each request")
+	flag.IntVar(&retryPolicy.MaxAttempts, "attempts", retryPolicy.MaxAttempts,
+		"Maximum number of requests for a URL on temporary failures (1 for no retry)")
+	flag.DurationVar(&retryPolicy.BaseDelay, "backoff", retryPolicy.BaseDelay,
+		"Delay before the first retry, doubled on each new retry")
+	flag.DurationVar(&retryPolicy.MaxDelay, "max-backoff", retryPolicy.MaxDelay,
+		"Maximum delay between retries, also limiting the accepted Retry-After (0 for no limit)")
+	flag.DurationVar(&clientConfig.Timeout, "request-timeout", clientConfig.Timeout,
+		"Maximum duration of each request, including the content download (0 for no limit)")
+	flag.DurationVar(&clientConfig.ConnectTimeout, "connect-timeout", 0,
+		"Maximum duration to establish a connection (0 for the system default)")
+	flag.StringVar(&clientConfig.ProxyURL, "proxy", "",
+		"Address of the HTTP proxy (environment variables are used when not defined)")
+	flag.StringVar(&clientConfig.CAFile, "ca-file", "",
+		"PEM file with additional certificate authorities to trust")
+	flag.BoolVar(&clientConfig.InsecureSkipVerify, "insecure", false,
+		"Don't verify TLS certificates (only for staging sites)")
+	flag.StringVar(&httpFetcher.UserAgent, "user-agent", "", "User-Agent header of the requests")
+	flag.Var(headerFlag(httpFetcher.Header), "header",
+		"Header sent in all requests in the format \"Name: value\" (can be repeated)")
+	flag.StringVar(&subdomains, "subdomains", "",
+		"Comma separated list of subdomains also crawled (* for all)")
+	flag.StringVar(&pathPrefix, "path", "", "Only crawl pages with this path prefix")
+	flag.BoolVar(&options.CheckResources, "check", false,
+		"Check external links and static assets, reporting the broken ones")
+	flag.BoolVar(&options.CrawlStylesheets, "css", false,
+		"Analyze the stylesheets of the site to find fonts, images and imported stylesheets")
+	flag.StringVar(&options.UserAgent, "robots-agent", options.UserAgent,
+		"User agent token used to select the rules of the robots.txt")
+	flag.BoolVar(&options.IgnoreRobots, "ignore-robots", false,
+		"Crawl the pages disallowed by the robots.txt (only for your own sites)")
+	flag.BoolVar(&options.IgnoreNofollow, "ignore-nofollow", false,
+		"Follow the links marked as nofollow by the pages")
+	flag.BoolVar(&options.DedupeCanonical, "dedupe-canonical", false,
+		"Crawl only one page for each canonical URL")
+	flag.BoolVar(&canonicalReport, "canonical", false,
+		"Report the pages whose canonical URL points to another page")
+	flag.BoolVar(&options.UseSitemaps, "sitemaps", false,
+		"Also crawl the pages of the sitemaps, reporting orphan pages and pages missing from them")
+	flag.StringVar(&inlinks, "inlinks", "", "Report the pages that link to this URL")
+	flag.BoolVar(&redirectsReport, "redirects", false,
+		"Report the long redirect chains and the chains that leave the site")
+	flag.IntVar(&maxRedirects, "max-redirects", 2,
+		"Redirect chains longer than this are reported (see -redirects)")
+	flag.StringVar(&format, "format", "text",
+		"Output format of the site map (text, json, sitemap or dot)")
+	flag.StringVar(&format, "f", "text",
+		"Output format of the site map (text, json, sitemap or dot)")
+	flag.StringVar(&output, "output", "",
+		"Directory where the sitemap files are written (needed for more than 50,000 pages)")
+	flag.BoolVar(&compress, "gzip", false, "Compress the sitemap files with gzip")
+	flag.IntVar(&cluster, "cluster", 0,
+		"Group the pages of the dot graph by this number of path segments (0 for no clusters)")
 	flag.Parse()
 
-	if len(url) == 0 {
+	if len(seedsFile) > 0 {
+		seeds, err := readSeeds(seedsFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(ErrInputParameters)
+		}
+		urls = append(urls, seeds...)
+	}
+
+	if len(urls) == 0 {
 		fmt.Println("URL parameter is mandatory")
 		flag.PrintDefaults()
 		os.Exit(ErrInputParameters)
 	}
 
-	fmt.Printf(`
+	if format != "text" && format != "json" && format != "sitemap" &&
+		format != "dot" {
+		fmt.Printf("Unknown output format %s\n", format)
+		flag.PrintDefaults()
+		os.Exit(ErrInputParameters)
+	}
+
+	// Only the text format is designed for humans, the other formats are parsed by tools, so
+	// any additional information is written in the standard error
+	var info io.Writer = os.Stdout
+	if format != "text" {
+		info = os.Stderr
+	}
+
+	// The first URL defines the site, the other URLs are additional entry points
+	scope, err := crawler.NewScope(urls[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(ErrInputParameters)
+	}
+
+	if len(subdomains) > 0 {
+		scope.Subdomains = strings.Split(subdomains, ",")
+	}
+	scope.PathPrefix = pathPrefix
+	options.Scope = scope
+
+	for _, url := range urls {
+		if !scope.Contains(url) {
+			fmt.Printf("Start URL %s is outside the site of %s (see -subdomains and -path)\n",
+				url, urls[0])
+			os.Exit(ErrInputParameters)
+		}
+	}
+
+	httpFetcher.Client, err = crawler.NewHTTPClient(clientConfig)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(ErrInputParameters)
+	}
+
+	if format == "text" {
+		fmt.Printf(`
 ＷＥＢ ＣＲＡＷＬＥＲ - %s
 
 ┏━━━━━━━━━━━━━━━━━━━━━━┓
@@ -60,18 +236,200 @@ func main() {
 ┃ ▤ Static Asset       ┃
 ┃ ↺ Already visited    ┃
 ┃ ✗ Fail to download   ┃
+┃ ⊘ Not crawled        ┃
 ┃                      ┃
 ┗━━━━━━━━━━━━━━━━━━━━━━┛
 
 Analyzing domain...
-`, url)
+`, urls.String())
+	}
+
+	// The limits for each host are applied to page fetches and resource checks
+	var fetcher crawler.Fetcher = httpFetcher
+	if limits != (crawler.HostLimits{}) {
+		fetcher = crawler.NewRateLimitedFetcher(fetcher, limits)
+	}
+
+	// Each retry also respects the limits of the host
+	if retryPolicy.MaxAttempts > 1 {
+		fetcher = crawler.NewRetryFetcher(fetcher, retryPolicy)
+	}
+
+	// The first page stores the other start pages, so it has the whole result of the crawl
+	pages, err := crawler.CrawlSeeds(context.Background(), urls, fetcher, options)
+	if len(pages) == 0 {
+		fmt.Fprintln(info, err)
+		os.Exit(ErrCrawlerExecution)
+	}
+	page := pages[0]
+
+	switch format {
+	case "json":
+		if err := crawler.WriteJSON(os.Stdout, page, options.Normalizer); err != nil {
+			fmt.Fprintln(info, err)
+			os.Exit(ErrOutput)
+		}
 
-	page, err := crawler.Crawl(url, crawler.HTTPFetcher{})
+	case "sitemap":
+		if err := writeSitemap(page, scope, output, compress); err != nil {
+			fmt.Fprintln(info, err)
+			os.Exit(ErrOutput)
+		}
+
+	case "dot":
+		if err := crawler.WriteDOT(os.Stdout, page, options.Normalizer, cluster); err != nil {
+			fmt.Fprintln(info, err)
+			os.Exit(ErrOutput)
+		}
+
+	default:
+		fmt.Println("Building output...")
+		fmt.Println(page)
+	}
+
+	// When the crawl was interrupted we still show the pages that were analyzed
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(info, err)
 		os.Exit(ErrCrawlerExecution)
 	}
 
-	fmt.Println("Building output...")
-	fmt.Println(page)
+	if options.UseSitemaps {
+		printSitemapCoverage(info, page, options.Scope, options.Normalizer)
+	}
+
+	if len(inlinks) > 0 {
+		printInlinks(info, page, inlinks, options.Normalizer)
+	}
+
+	if canonicalReport {
+		canonicalIssues := crawler.CanonicalIssues(page, options.Normalizer)
+		if len(canonicalIssues) > 0 {
+			fmt.Fprintf(info, "\nCanonical mismatches (%d):\n\n", len(canonicalIssues))
+			for _, canonicalIssue := range canonicalIssues {
+				fmt.Fprintf(info, "%s\n\n", canonicalIssue)
+			}
+		}
+	}
+
+	if redirectsReport {
+		redirectChains := crawler.RedirectChains(page, maxRedirects, scope)
+		if len(redirectChains) > 0 {
+			fmt.Fprintf(info, "\nRedirect chains (%d):\n\n", len(redirectChains))
+			for _, redirectChain := range redirectChains {
+				fmt.Fprintf(info, "%s\n\n", redirectChain)
+			}
+		}
+	}
+
+	if options.CheckResources {
+		brokenLinks := crawler.BrokenLinks(page)
+		if len(brokenLinks) > 0 {
+			fmt.Fprintf(info, "\nBroken links (%d):\n\n", len(brokenLinks))
+			for _, brokenLink := range brokenLinks {
+				fmt.Fprintf(info, "%s\n\n", brokenLink)
+			}
+			os.Exit(ErrBrokenLinks)
+		}
+
+		fmt.Fprintln(info, "\nNo broken links found")
+	}
+}
+
+// printSitemapCoverage writes the pages of the sitemaps that aren't linked by the site and the
+// pages of the site that aren't listed in the sitemaps
+func printSitemapCoverage(w io.Writer, page *crawler.Page, scope *crawler.Scope,
+	normalizer crawler.Normalizer) {
+	sitemapPages := 0
+	for _, seed := range page.Seeds {
+		if seed.Sitemap {
+			sitemapPages++
+		}
+	}
+
+	if sitemapPages == 0 {
+		fmt.Fprintln(w, "\nNo pages found in the sitemaps")
+		return
+	}
+
+	orphanPages := crawler.OrphanPages(page, normalizer)
+	if len(orphanPages) > 0 {
+		fmt.Fprintf(w, "\nOrphan pages (%d):\n\n", len(orphanPages))
+		for _, orphanPage := range orphanPages {
+			fmt.Fprintf(w, "⚑ %s\n", orphanPage)
+		}
+	}
+
+	missingPages := crawler.MissingFromSitemap(page, scope, normalizer)
+	if len(missingPages) > 0 {
+		fmt.Fprintf(w, "\nPages missing from the sitemaps (%d):\n\n", len(missingPages))
+		for _, missingPage := range missingPages {
+			fmt.Fprintf(w, "∅ %s\n", missingPage)
+		}
+	}
+}
+
+// printInlinks writes the pages that link to the URL, with the labels of the links
+func printInlinks(w io.Writer, page *crawler.Page, url string, normalizer crawler.Normalizer) {
+	graph := crawler.NewSiteGraph(page, normalizer)
+
+	edges := graph.Inlinks(url)
+	if len(edges) == 0 {
+		fmt.Fprintf(w, "\nNo pages link to %s\n", url)
+		return
+	}
+
+	fmt.Fprintf(w, "\nPages that link to %s (%d):\n\n", url, len(edges))
+	for _, edge := range edges {
+		fmt.Fprintf(w, "↳ %s (%d) \"%s\"\n", edge.From, edge.Count,
+			strings.Join(edge.Labels, "\", \""))
+	}
+}
+
+// readSeeds reads the start URLs of a file, one per line. Empty lines and comments are
+// ignored
+func readSeeds(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var seeds []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			seeds = append(seeds, line)
+		}
+	}
+
+	return seeds, scanner.Err()
+}
+
+// writeSitemap writes the sitemaps.org XML of the crawled pages. When the output directory is
+// defined the files are created there, otherwise the sitemap is written in the standard output
+func writeSitemap(page *crawler.Page, scope *crawler.Scope, output string, compress bool) error {
+	if len(output) > 0 {
+		baseURL := scope.Scheme + "://" + scope.Host
+		if len(scope.Port) > 0 {
+			baseURL += ":" + scope.Port
+		}
+
+		_, err := crawler.ExportSitemaps(output, baseURL, page, scope, compress)
+		return err
+	}
+
+	if !compress {
+		return crawler.WriteSitemap(os.Stdout, crawler.SitemapURLs(page, scope))
+	}
+
+	gzipWriter := gzip.NewWriter(os.Stdout)
+	if err := crawler.WriteSitemap(gzipWriter, crawler.SitemapURLs(page, scope)); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
 }
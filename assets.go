@@ -0,0 +1,340 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"code.google.com/p/go.net/html"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// AssetKind classifies the static assets of a page
+type AssetKind string
+
+// List of possible kinds of static assets
+const (
+	AssetImage      AssetKind = "image"      // Images, icons and posters
+	AssetScript     AssetKind = "script"     // JavaScript files
+	AssetStylesheet AssetKind = "stylesheet" // CSS files
+	AssetMedia      AssetKind = "media"      // Audio, video and text tracks
+	AssetFont       AssetKind = "font"       // Web fonts
+	AssetFrame      AssetKind = "frame"      // Documents embedded in the page
+	AssetOther      AssetKind = "other"      // Anything else, like manifests and alternate versions
+)
+
+// Asset describes a static dependency of a page, like an image or a stylesheet, and where it
+// was referenced in the page
+type Asset struct {
+	URL         string    // Address of the asset, resolved against the page URL
+	Raw         string    // Reference as it was written in the page
+	Kind        AssetKind // Type of content of the asset
+	Tag         string    // Element that references the asset, empty when found in a stylesheet
+	Attribute   string    // Attribute that references the asset, empty for <style> blocks
+	Rel         string    // Relation types of the element, like "stylesheet" or "preload"
+	Type        string    // Media type declared by the element
+	Integrity   string    // Subresource integrity metadata of the element
+	CrossOrigin string    // CORS settings of the element, empty when they aren't defined
+}
+
+// elementAsset describes an attribute of an element that references a static asset
+type elementAsset struct {
+	attribute string    // Name of the attribute
+	kind      AssetKind // Kind of the referenced asset
+	srcset    bool      // Flag to indicate that the attribute has a list of image candidates
+}
+
+// elementAssets lists the attributes that reference static assets for each element. The <link>,
+// <source> and <meta> elements depend on other attributes and are analyzed separately
+var elementAssets = map[string][]elementAsset{
+	"img": {
+		{attribute: "src", kind: AssetImage},
+		{attribute: "srcset", kind: AssetImage, srcset: true},
+	},
+	"script": {
+		{attribute: "src", kind: AssetScript},
+	},
+	"video": {
+		{attribute: "src", kind: AssetMedia},
+		{attribute: "poster", kind: AssetImage},
+	},
+	"audio": {
+		{attribute: "src", kind: AssetMedia},
+	},
+	"track": {
+		{attribute: "src", kind: AssetMedia},
+	},
+	"iframe": {
+		{attribute: "src", kind: AssetFrame},
+	},
+	"frame": {
+		{attribute: "src", kind: AssetFrame},
+	},
+	"embed": {
+		{attribute: "src", kind: AssetFrame},
+	},
+	"object": {
+		{attribute: "data", kind: AssetFrame},
+	},
+}
+
+// metaImageProperties lists the <meta> properties (or names) that reference the image shown
+// when the page is shared
+var metaImageProperties = []string{
+	"og:image",
+	"og:image:url",
+	"og:image:secure_url",
+	"twitter:image",
+	"twitter:image:src",
+}
+
+// fontExtensions lists the file extensions of web fonts, used to classify the CSS references
+var fontExtensions = []string{".woff", ".woff2", ".ttf", ".otf", ".eot"}
+
+// parseAssets identifies all static assets referenced by the element, including the CSS
+// references of the style attribute and of the <style> blocks. Each asset is resolved
+// against the base URL and stored in the page
+func parseAssets(node *html.Node, page *Page, base *url.URL) {
+	add := func(attributeName, rawURL string, kind AssetKind) {
+		rawURL, ok := assetReference(rawURL)
+		if !ok {
+			return
+		}
+
+		asset := Asset{
+			URL:       resolveURL(base, rawURL),
+			Raw:       rawURL,
+			Kind:      kind,
+			Tag:       node.Data,
+			Attribute: attributeName,
+		}
+		asset.Rel, _ = attribute(node, "rel")
+		asset.Type, _ = attribute(node, "type")
+		asset.Integrity, _ = attribute(node, "integrity")
+
+		// An empty crossorigin attribute has the same meaning of the "anonymous" keyword
+		if crossOrigin, found := attribute(node, "crossorigin"); found {
+			asset.CrossOrigin = crossOrigin
+			if len(asset.CrossOrigin) == 0 {
+				asset.CrossOrigin = "anonymous"
+			}
+		}
+
+		page.StaticAssets = addAsset(page.StaticAssets, asset)
+	}
+
+	for _, elementAsset := range elementAssets[node.Data] {
+		value, found := attribute(node, elementAsset.attribute)
+		if !found {
+			continue
+		}
+
+		if elementAsset.srcset {
+			for _, candidate := range parseSrcset(value) {
+				add(elementAsset.attribute, candidate, elementAsset.kind)
+			}
+		} else {
+			add(elementAsset.attribute, value, elementAsset.kind)
+		}
+	}
+
+	switch node.Data {
+	case "link":
+		// The canonical address is also stored in the page (see Page.Canonical)
+		if href, found := attribute(node, "href"); found {
+			rel, _ := attribute(node, "rel")
+			as, _ := attribute(node, "as")
+			add("href", href, linkAssetKind(rel, as, href))
+		}
+
+	case "source":
+		// Sources of <video> and <audio> are media files, while the sources of <picture> are
+		// images
+		kind := AssetImage
+		if node.Parent != nil && (node.Parent.Data == "video" || node.Parent.Data == "audio") {
+			kind = AssetMedia
+		}
+
+		if src, found := attribute(node, "src"); found {
+			add("src", src, kind)
+		}
+		if srcset, found := attribute(node, "srcset"); found {
+			for _, candidate := range parseSrcset(srcset) {
+				add("srcset", candidate, kind)
+			}
+		}
+
+	case "meta":
+		property, found := attribute(node, "property")
+		if !found {
+			property, _ = attribute(node, "name")
+		}
+
+		for _, metaImageProperty := range metaImageProperties {
+			if strings.EqualFold(property, metaImageProperty) {
+				if content, found := attribute(node, "content"); found {
+					add("content", content, AssetImage)
+				}
+				break
+			}
+		}
+
+	case "style":
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.TextNode {
+				for _, reference := range parseCSSURLs(child.Data) {
+					add("", reference.url, cssReferenceKind(reference))
+				}
+			}
+		}
+	}
+
+	if style, found := attribute(node, "style"); found {
+		for _, reference := range parseCSSURLs(style) {
+			add("style", reference.url, cssReferenceKind(reference))
+		}
+	}
+}
+
+// attribute returns the value of the element attribute
+func attribute(node *html.Node, key string) (string, bool) {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// linkAssetKind classifies the resource referenced by a <link> element using the relation
+// types and, for preloaded resources, the destination defined in the "as" attribute
+func linkAssetKind(rel, as, href string) AssetKind {
+	for _, relType := range strings.Fields(strings.ToLower(rel)) {
+		switch relType {
+		case "stylesheet":
+			return AssetStylesheet
+		case "icon", "apple-touch-icon", "apple-touch-icon-precomposed", "mask-icon":
+			return AssetImage
+		case "modulepreload":
+			return AssetScript
+		case "preload", "prefetch":
+			switch strings.ToLower(as) {
+			case "style":
+				return AssetStylesheet
+			case "script", "worker":
+				return AssetScript
+			case "font":
+				return AssetFont
+			case "image":
+				return AssetImage
+			case "audio", "video", "track":
+				return AssetMedia
+			case "document", "embed", "object":
+				return AssetFrame
+			}
+			return cssAssetKind(href)
+		}
+	}
+
+	return AssetOther
+}
+
+// cssAssetKind classifies a resource referenced by a CSS content using the file extension.
+// Fonts and other stylesheets are identified, and everything else is considered an image
+func cssAssetKind(rawURL string) AssetKind {
+	if index := strings.IndexAny(rawURL, "?#"); index >= 0 {
+		rawURL = rawURL[:index]
+	}
+
+	extension := strings.ToLower(path.Ext(rawURL))
+	if extension == ".css" {
+		return AssetStylesheet
+	}
+
+	for _, fontExtension := range fontExtensions {
+		if extension == fontExtension {
+			return AssetFont
+		}
+	}
+
+	return AssetImage
+}
+
+// cssReferenceKind classifies a reference of a CSS content. Addresses of @import rules are
+// always stylesheets, the others are classified by the file extension
+func cssReferenceKind(reference cssReference) AssetKind {
+	if reference.imported {
+		return AssetStylesheet
+	}
+	return cssAssetKind(reference.url)
+}
+
+// assetReference returns the reference without the surrounding spaces, checking if it points
+// to a resource. Empty references and inline data (data: URIs) don't point to any resource
+func assetReference(rawURL string) (string, bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	if len(rawURL) == 0 || strings.HasPrefix(strings.ToLower(rawURL), "data:") {
+		return "", false
+	}
+	return rawURL, true
+}
+
+// addAsset appends the asset to the list when there's no other asset with the same URL. An
+// asset referenced many times is stored only once, with the details of the first reference
+func addAsset(assets []Asset, asset Asset) []Asset {
+	for _, existing := range assets {
+		if existing.URL == asset.URL {
+			return assets
+		}
+	}
+	return append(assets, asset)
+}
+
+// parseSrcset returns the URLs of the image candidates of a srcset attribute. Each candidate
+// is an URL followed by optional descriptors, and the candidates are separated by commas
+func parseSrcset(srcset string) []string {
+	var urls []string
+
+	for {
+		srcset = strings.TrimLeft(srcset, " \t\n\r\f,")
+		if len(srcset) == 0 {
+			break
+		}
+
+		end := strings.IndexAny(srcset, " \t\n\r\f")
+		if end < 0 {
+			end = len(srcset)
+		}
+		candidate := srcset[:end]
+		srcset = srcset[end:]
+
+		if strings.HasSuffix(candidate, ",") {
+			// A comma in the end of the URL means that the candidate has no descriptors
+			candidate = strings.TrimRight(candidate, ",")
+
+		} else {
+			// Skip the descriptors until the next candidate, commas inside parentheses don't
+			// separate candidates
+			depth := 0
+			i := 0
+			for ; i < len(srcset); i++ {
+				if srcset[i] == '(' {
+					depth++
+				} else if srcset[i] == ')' && depth > 0 {
+					depth--
+				} else if srcset[i] == ',' && depth == 0 {
+					break
+				}
+			}
+			srcset = srcset[i:]
+		}
+
+		if len(candidate) > 0 {
+			urls = append(urls, candidate)
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,228 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCrawlMustExtractStaticAssets(t *testing.T) {
+	data := `<html>
+  <head>
+    <link rel="icon" href="/favicon.ico">
+    <link rel="manifest" href="/site.webmanifest">
+    <link rel="preload" as="font" href="/fonts/text.woff2">
+    <link rel="stylesheet" href="style.css">
+    <meta property="og:image" content="http://cdn.example.com/share.jpg">
+    <style>
+      /* background: url(commented.png); */
+      @import "print.css";
+      body { background: url('bg.png'); }
+      @font-face { src: url(/fonts/title.woff) format("woff"); }
+    </style>
+  </head>
+  <body style="background-image: url(&quot;body.png&quot;)">
+    <img src="small.png" srcset="small.png 1x, large.png 2x">
+    <img src="data:image/png;base64,AAAA">
+    <picture>
+      <source srcset="photo.webp 100w, photo-2x.webp 200w" type="image/webp">
+      <img src="photo.jpg">
+    </picture>
+    <video src="movie.mp4" poster="poster.jpg">
+      <source src="movie.webm" type="video/webm">
+      <track src="subtitles.vtt" kind="subtitles">
+    </video>
+    <audio><source src="song.ogg"></audio>
+    <iframe src="http://example.net/widget.html"></iframe>
+    <embed src="animation.swf">
+    <object data="document.pdf"></object>
+    <script src="app.js"></script>
+  </body>
+</html>`
+
+	expected := []struct {
+		url  string
+		kind AssetKind
+	}{
+		{url: "http://example.com/favicon.ico", kind: AssetImage},
+		{url: "http://example.com/site.webmanifest", kind: AssetOther},
+		{url: "http://example.com/fonts/text.woff2", kind: AssetFont},
+		{url: "http://example.com/style.css", kind: AssetStylesheet},
+		{url: "http://cdn.example.com/share.jpg", kind: AssetImage},
+		{url: "http://example.com/print.css", kind: AssetStylesheet},
+		{url: "http://example.com/bg.png", kind: AssetImage},
+		{url: "http://example.com/fonts/title.woff", kind: AssetFont},
+		{url: "http://example.com/body.png", kind: AssetImage},
+		{url: "http://example.com/small.png", kind: AssetImage},
+		{url: "http://example.com/large.png", kind: AssetImage},
+		{url: "http://example.com/photo.webp", kind: AssetImage},
+		{url: "http://example.com/photo-2x.webp", kind: AssetImage},
+		{url: "http://example.com/photo.jpg", kind: AssetImage},
+		{url: "http://example.com/movie.mp4", kind: AssetMedia},
+		{url: "http://example.com/poster.jpg", kind: AssetImage},
+		{url: "http://example.com/movie.webm", kind: AssetMedia},
+		{url: "http://example.com/subtitles.vtt", kind: AssetMedia},
+		{url: "http://example.com/song.ogg", kind: AssetMedia},
+		{url: "http://example.net/widget.html", kind: AssetFrame},
+		{url: "http://example.com/animation.swf", kind: AssetFrame},
+		{url: "http://example.com/document.pdf", kind: AssetFrame},
+		{url: "http://example.com/app.js", kind: AssetScript},
+	}
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		return htmlResponse(url, data), nil
+	})
+
+	page, err := Crawl("http://example.com", fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(page.StaticAssets) != len(expected) {
+		t.Fatalf("Unexpected number of static assets. Expected %d and got %d: %v",
+			len(expected), len(page.StaticAssets), page.StaticAssets)
+	}
+
+	for i, item := range expected {
+		asset := page.StaticAssets[i]
+		if asset.URL != item.url || asset.Kind != item.kind {
+			t.Errorf("Unexpected asset at position %d. Expected '%s' (%s) and got '%s' (%s)",
+				i, item.url, item.kind, asset.URL, asset.Kind)
+		}
+	}
+}
+
+func TestCrawlMustStoreAssetDetails(t *testing.T) {
+	data := `<html>
+  <head>
+    <link rel="preload" as="style" href="/css/main.css" integrity="sha384-abc" crossorigin>
+    <link rel="stylesheet" href="/css/main.css">
+    <link rel="alternate" type="application/rss+xml" href="feed.xml">
+    <style>body { background: url(bg.png) }</style>
+  </head>
+  <body>
+    <script type="module" src="https://cdn.example.net/app.js" crossorigin="use-credentials"></script>
+    <div style="background: url(div.png)"></div>
+  </body>
+</html>`
+
+	expected := []Asset{
+		{
+			URL:         "http://example.com/css/main.css",
+			Raw:         "/css/main.css",
+			Kind:        AssetStylesheet,
+			Tag:         "link",
+			Attribute:   "href",
+			Rel:         "preload",
+			Integrity:   "sha384-abc",
+			CrossOrigin: "anonymous",
+		},
+		{
+			URL:       "http://example.com/docs/feed.xml",
+			Raw:       "feed.xml",
+			Kind:      AssetOther,
+			Tag:       "link",
+			Attribute: "href",
+			Rel:       "alternate",
+			Type:      "application/rss+xml",
+		},
+		{
+			URL:  "http://example.com/docs/bg.png",
+			Raw:  "bg.png",
+			Kind: AssetImage,
+			Tag:  "style",
+		},
+		{
+			URL:         "https://cdn.example.net/app.js",
+			Raw:         "https://cdn.example.net/app.js",
+			Kind:        AssetScript,
+			Tag:         "script",
+			Attribute:   "src",
+			Type:        "module",
+			CrossOrigin: "use-credentials",
+		},
+		{
+			URL:       "http://example.com/docs/div.png",
+			Raw:       "div.png",
+			Kind:      AssetImage,
+			Tag:       "div",
+			Attribute: "style",
+		},
+	}
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		return htmlResponse(url, data), nil
+	})
+
+	page, err := Crawl("http://example.com/docs/", fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(page.StaticAssets, expected) {
+		t.Errorf("Unexpected static assets. Expected '%+v' and got '%+v'",
+			expected, page.StaticAssets)
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	testData := []struct {
+		srcset   string
+		expected []string
+	}{
+		{srcset: "", expected: nil},
+		{srcset: "image.png", expected: []string{"image.png"}},
+		{srcset: "small.png 1x, large.png 2x", expected: []string{"small.png", "large.png"}},
+		{srcset: "small.png, large.png 2x", expected: []string{"small.png", "large.png"}},
+		{
+			srcset:   "  a.png 100w,\n  b.png 200w , c.png  ",
+			expected: []string{"a.png", "b.png", "c.png"},
+		},
+		{
+			srcset:   "a.png calc(1px, 2px), b.png",
+			expected: []string{"a.png", "b.png"},
+		},
+		{
+			srcset:   "image.png?w=1,2 1x",
+			expected: []string{"image.png?w=1,2"},
+		},
+	}
+
+	for _, testItem := range testData {
+		if urls := parseSrcset(testItem.srcset); !reflect.DeepEqual(urls, testItem.expected) {
+			t.Errorf("Unexpected URLs for srcset '%s'. Expected '%v' and got '%v'",
+				testItem.srcset, testItem.expected, urls)
+		}
+	}
+}
+
+func TestLinkAssetKind(t *testing.T) {
+	testData := []struct {
+		rel      string
+		as       string
+		href     string
+		expected AssetKind
+	}{
+		{rel: "stylesheet", href: "style.css", expected: AssetStylesheet},
+		{rel: "Alternate Stylesheet", href: "style.css", expected: AssetStylesheet},
+		{rel: "shortcut icon", href: "favicon.ico", expected: AssetImage},
+		{rel: "apple-touch-icon", href: "icon.png", expected: AssetImage},
+		{rel: "manifest", href: "site.webmanifest", expected: AssetOther},
+		{rel: "modulepreload", href: "module.js", expected: AssetScript},
+		{rel: "preload", as: "script", href: "app.js", expected: AssetScript},
+		{rel: "preload", as: "font", href: "font.woff2", expected: AssetFont},
+		{rel: "prefetch", as: "video", href: "movie.mp4", expected: AssetMedia},
+		{rel: "preload", href: "font.woff2", expected: AssetFont},
+		{rel: "", href: "unknown", expected: AssetOther},
+	}
+
+	for _, testItem := range testData {
+		if kind := linkAssetKind(testItem.rel, testItem.as, testItem.href); kind != testItem.expected {
+			t.Errorf("Unexpected kind for link '%s' as '%s'. Expected '%s' and got '%s'",
+				testItem.rel, testItem.as, testItem.expected, kind)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"strings"
+)
+
+const (
+	// DefaultMaxBodySize is the maximum number of bytes of a page analyzed by default. Bigger
+	// pages are marked as failed
+	DefaultMaxBodySize = 10 * 1024 * 1024
+)
+
+var (
+	// ErrBodyTooLarge is used when the content of a page is bigger than the maximum body size
+	// of the crawl (see CrawlOptions.MaxBodySize)
+	ErrBodyTooLarge = errors.New("response body too large")
+)
+
+// HTMLContentTypes lists the media types of the pages that are analyzed. Content of other
+// types, like images and PDFs, is stored as a document without being downloaded
+var HTMLContentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+}
+
+// isHTML checks if the content type of the response is one of the HTMLContentTypes. When the
+// content type is unknown we assume that it's HTML, as the parser can deal with anything
+func isHTML(contentType string) bool {
+	if len(strings.TrimSpace(contentType)) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, htmlContentType := range HTMLContentTypes {
+		if mediaType == htmlContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyReader reads the content of a response respecting the maximum size, and counts the
+// number of bytes read
+type bodyReader struct {
+	reader io.Reader // Content of the response
+	limit  int64     // Maximum number of bytes, zero for no limit
+	read   int64     // Number of bytes already read
+}
+
+// newBodyReader wraps the content of the response with a maximum size
+func newBodyReader(reader io.Reader, limit int64) *bodyReader {
+	return &bodyReader{
+		reader: reader,
+		limit:  limit,
+	}
+}
+
+// Read reads the content until the limit, returning ErrBodyTooLarge when there's more content
+// after it
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.limit > 0 && b.read >= b.limit {
+		// We only fail when there's really more content, so a body with exactly the maximum size
+		// is accepted
+		var probe [1]byte
+		n, err := b.reader.Read(probe[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read {
+		p = p[:b.limit-b.read]
+	}
+
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// closeBody releases the content of the response when it can be closed, like the streamed
+// content of the HTTPFetcher. The content that wasn't read yet is never downloaded
+func closeBody(response *Response) {
+	if response == nil {
+		return
+	}
+
+	if closer, ok := response.Body.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestIsHTML(t *testing.T) {
+	testData := []struct {
+		contentType string
+		expected    bool
+	}{
+		{contentType: "", expected: true},
+		{contentType: "text/html", expected: true},
+		{contentType: "text/html; charset=utf-8", expected: true},
+		{contentType: "TEXT/HTML", expected: true},
+		{contentType: "application/xhtml+xml", expected: true},
+		{contentType: "application/pdf", expected: false},
+		{contentType: "image/png", expected: false},
+		{contentType: "text/plain", expected: false},
+		{contentType: "text/html; charset", expected: false},
+	}
+
+	for _, testItem := range testData {
+		if html := isHTML(testItem.contentType); html != testItem.expected {
+			t.Errorf("Unexpected result for content type '%s'. Expected '%t' and got '%t'",
+				testItem.contentType, testItem.expected, html)
+		}
+	}
+}
+
+func TestBodyReader(t *testing.T) {
+	testData := []struct {
+		content     string
+		limit       int64
+		expectedErr error
+	}{
+		{content: "<html></html>", limit: 0},
+		{content: "<html></html>", limit: 13},
+		{content: "<html></html>", limit: 100},
+		{content: "<html></html>", limit: 12, expectedErr: ErrBodyTooLarge},
+		{content: "<html></html>", limit: 1, expectedErr: ErrBodyTooLarge},
+	}
+
+	for _, testItem := range testData {
+		body := newBodyReader(strings.NewReader(testItem.content), testItem.limit)
+		content, err := ioutil.ReadAll(body)
+
+		if err != testItem.expectedErr {
+			t.Errorf("Unexpected error with limit %d. Expected '%v' and got '%v'",
+				testItem.limit, testItem.expectedErr, err)
+		}
+
+		if err == nil && (string(content) != testItem.content || body.read != int64(len(content))) {
+			t.Errorf("Unexpected content with limit %d. Got '%s' and %d bytes read",
+				testItem.limit, content, body.read)
+		}
+	}
+}
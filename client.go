@@ -0,0 +1,106 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultHTTPTimeout is the maximum duration of a request, including the time to read the
+	// response body, used when the HTTPFetcher has no client. Without a limit a server that
+	// never answers would hold a slot of the crawl forever
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// defaultHTTPClient is used by the HTTPFetcher when no client is defined
+var defaultHTTPClient = &http.Client{
+	Timeout: DefaultHTTPTimeout,
+}
+
+// HTTPClientConfig stores the network parameters of the client used by the HTTPFetcher. Zero
+// values keep the behaviour of the Go default client
+type HTTPClientConfig struct {
+	Timeout            time.Duration // Maximum duration of a request, including reading the body
+	ConnectTimeout     time.Duration // Maximum duration to establish a TCP connection
+	ProxyURL           string        // Address of the proxy, when empty the environment is used
+	CAFile             string        // PEM file with additional trusted certificate authorities
+	InsecureSkipVerify bool          // Accept any TLS certificate, only for test environments
+}
+
+// NewHTTPClient builds an HTTP client with the given configuration. An error is returned when
+// the proxy URL is invalid or the certificate authorities can't be loaded
+func NewHTTPClient(config HTTPClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if len(config.ProxyURL) > 0 {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err)
+		}
+
+		if len(proxyURL.Scheme) == 0 || len(proxyURL.Host) == 0 {
+			return nil, fmt.Errorf("invalid proxy URL: %s", config.ProxyURL)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.ConnectTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   config.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	if len(config.CAFile) > 0 || config.InsecureSkipVerify {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: config.InsecureSkipVerify,
+		}
+
+		if len(config.CAFile) > 0 {
+			rootCAs, err := loadCAFile(config.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = rootCAs
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+	}, nil
+}
+
+// loadCAFile adds the certificates of the PEM file to the certificate authorities of the
+// system
+func loadCAFile(filename string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	if !rootCAs.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+
+	return rootCAs, nil
+}
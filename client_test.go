@@ -0,0 +1,159 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClientMustConfigureTLS(t *testing.T) {
+	httpTestServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><body></body></html>")
+	}))
+	defer httpTestServer.Close()
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	content := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: httpTestServer.Certificate().Raw,
+	})
+	if err := ioutil.WriteFile(caFile, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidCAFile := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalidCAFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		description string
+		config      HTTPClientConfig
+		expectedErr bool
+		requestErr  bool
+	}{
+		{
+			description: "default",
+			config:      HTTPClientConfig{},
+			requestErr:  true,
+		},
+		{
+			description: "custom CA",
+			config:      HTTPClientConfig{CAFile: caFile},
+		},
+		{
+			description: "insecure",
+			config:      HTTPClientConfig{InsecureSkipVerify: true},
+		},
+		{
+			description: "invalid CA",
+			config:      HTTPClientConfig{CAFile: invalidCAFile},
+			expectedErr: true,
+		},
+		{
+			description: "missing CA",
+			config:      HTTPClientConfig{CAFile: filepath.Join(dir, "missing.pem")},
+			expectedErr: true,
+		},
+	}
+
+	for _, testItem := range testData {
+		client, err := NewHTTPClient(testItem.config)
+		if testItem.expectedErr {
+			if err == nil {
+				t.Errorf("No error returned on %s test", testItem.description)
+			}
+			continue
+
+		} else if err != nil {
+			t.Fatalf("Unexpected error on %s test: %s", testItem.description, err)
+		}
+
+		fetcher := HTTPFetcher{Client: client}
+		response, err := fetcher.Fetch(httpTestServer.URL)
+
+		if testItem.requestErr {
+			if err == nil {
+				t.Errorf("No request error on %s test", testItem.description)
+			}
+
+		} else if err != nil {
+			t.Errorf("Unexpected request error on %s test: %s", testItem.description, err)
+
+		} else if response.StatusCode != http.StatusOK {
+			t.Errorf("Unexpected status code on %s test. Expected %d and got %d",
+				testItem.description, http.StatusOK, response.StatusCode)
+		}
+	}
+}
+
+func TestNewHTTPClientMustUseProxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		fmt.Fprint(w, "<html><body></body></html>")
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(HTTPClientConfig{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := (HTTPFetcher{Client: client}).Fetch("http://example.com/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK || requested != "http://example.com/page.html" {
+		t.Errorf("Request not sent through the proxy. Proxy received '%s'", requested)
+	}
+
+	for _, proxyURL := range []string{"example.com:3128", "http://[::1"} {
+		if _, err := NewHTTPClient(HTTPClientConfig{ProxyURL: proxyURL}); err == nil {
+			t.Errorf("No error returned for invalid proxy URL '%s'", proxyURL)
+		}
+	}
+}
+
+func TestNewHTTPClientMustRespectTimeout(t *testing.T) {
+	release := make(chan bool)
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer httpTestServer.Close()
+	defer close(release)
+
+	client, err := NewHTTPClient(HTTPClientConfig{
+		Timeout:        50 * time.Millisecond,
+		ConnectTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	begin := time.Now()
+	if _, err := (HTTPFetcher{Client: client}).Fetch(httpTestServer.URL); err == nil {
+		t.Error("No error returned when the server doesn't answer")
+	}
+
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("Request not aborted by the timeout. Took %s", elapsed)
+	}
+}
@@ -7,106 +7,619 @@ package crawler
 
 import (
 	"code.google.com/p/go.net/html"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
 	"strings"
 )
 
 const (
-	// Maximum number of running go routines on the a crawl. Got code from
-	// http://golang.org/doc/effective_go.html#channels. This is necessary because we can go
-	// out of descriptors if we start creating go routines with no limit. There's also a
+	// DefaultMaxConcurrency is the default maximum number of running go routines on a crawl. Got
+	// code from http://golang.org/doc/effective_go.html#channels. This is necessary because we
+	// can go out of descriptors if we start creating go routines with no limit. There's also a
 	// great post about this on http://burke.libbey.me/conserving-file-descriptors-in-go/
-	maxOutstanding = 200
+	DefaultMaxConcurrency = 200
+
+	// maxSitemapFiles is the maximum number of sitemap files retrieved in a crawl, including
+	// the sitemap indexes, to avoid spending the crawl on sites with a huge number of sitemaps
+	maxSitemapFiles = 100
 )
 
 var (
-	// Semaphore to control go routines execution
-	sem = make(chan int, maxOutstanding)
+	// ErrNoStartURL is returned when a crawl is started without any URL
+	ErrNoStartURL = errors.New("no start URL informed")
+
+	// ErrStartURLOutOfScope is returned when a start URL isn't a page of the crawled site
+	ErrStartURLOutOfScope = errors.New("start URL outside the scope of the crawl")
 )
 
-func init() {
-	for i := 0; i < maxOutstanding; i++ {
-		sem <- 1
+// Crawl check all pages of the URL managing go routines. Only the pages of the same site of
+// the URL are crawled (see NewScope)
+func Crawl(url string, fetcher Fetcher) (*Page, error) {
+	return CrawlWithOptions(url, fetcher, DefaultCrawlOptions())
+}
+
+// CrawlWithOptions check all pages of the URL managing go routines, using the options to
+// control the crawling execution. Each call has its own limits, so concurrent crawls don't
+// interfere with each other
+func CrawlWithOptions(url string, fetcher Fetcher, options CrawlOptions) (*Page, error) {
+	return CrawlContext(context.Background(), url, fetcher, options)
+}
+
+// CrawlContext check all pages of the URL managing go routines, stopping to schedule new
+// pages when ctx is cancelled or its deadline is reached. In this case the partial Page tree
+// is returned together with the ctx error. When the fetcher is a ContextFetcher the ctx is
+// also used to abort the running fetches
+func CrawlContext(ctx context.Context, url string, fetcher Fetcher, options CrawlOptions) (*Page, error) {
+	pages, err := CrawlSeeds(ctx, []string{url}, fetcher, options)
+	if len(pages) == 0 {
+		return nil, err
 	}
+
+	return pages[0], err
 }
 
-// Crawl check all pages of the URL managing go routines
-func Crawl(url string, fetcher Fetcher) (*Page, error) {
-	page := &Page{
-		URL: url,
+// CrawlSeeds check all pages of the URLs managing go routines (see CrawlContext). The start
+// pages share the visited pages and the limits of the crawl, so a page reached from many start
+// pages is crawled only once. When the scope isn't defined in the options it's built from the
+// first URL, and only the sitemaps of its host are used. All URLs must be in the scope,
+// otherwise ErrStartURLOutOfScope is returned. The robots.txt of each host of the scope is
+// read when the first page of the host is found. One page is returned for each URL, in the
+// same order, and the other start pages are also stored as seeds of the first one (see
+// Page.Seeds), so the first page has the whole result of the crawl
+func CrawlSeeds(ctx context.Context, urls []string, fetcher Fetcher,
+	options CrawlOptions) ([]*Page, error) {
+
+	if len(urls) == 0 {
+		return nil, ErrNoStartURL
+	}
+
+	scope := options.Scope
+	if scope == nil {
+		var err error
+		if scope, err = NewScope(urls[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, url := range urls {
+		if !scope.Contains(url) {
+			return nil, ErrStartURLOutOfScope
+		}
+	}
+
+	context := NewCrawlerContext(ctx, scope, fetcher, options)
+	defer context.cancel()
+
+	var sitemapURLs []string
+	if options.UseSitemaps {
+		// The robots.txt is also read when its rules are ignored, to find the sitemaps
+		var robots *Robots
+		if hostRobots := context.hostRobots(urls[0]); hostRobots != nil {
+			robots = hostRobots.robots
+		} else {
+			robots = loadRobots(context, urls[0])
+		}
+
+		var sitemaps []string
+		if robots != nil {
+			sitemaps = robots.Sitemaps
+		}
+		sitemapURLs = loadSitemaps(context, urls[0], sitemaps)
+	}
+
+	// A start URL that was already informed shares the page of the first occurrence
+	pages := make([]*Page, len(urls))
+	pages[0] = seedPage(context, urls[0], false).Page
+	for i := 1; i < len(urls); i++ {
+		seed := seedPage(context, urls[i], false)
+		pages[0].Seeds = append(pages[0].Seeds, seed)
+		pages[i] = seed.Page
+	}
+	crawlLevels(context)
+
+	// The pages of the sitemaps are only crawled after following all links, so they are found
+	// on the shortest path from the start pages when possible, and only the pages that weren't
+	// reached by links become new start pages
+	for _, sitemapURL := range sitemapURLs {
+		pages[0].Seeds = append(pages[0].Seeds, seedPage(context, sitemapURL, true))
+	}
+	crawlLevels(context)
+
+	return pages, context.Err()
+}
+
+// loadRobots retrieves the robots.txt of the URL host, keeping the rules of the crawl user
+// agent. When the file doesn't exist or can't be retrieved there are no restrictions and nil is
+// returned, but a server error disallows the whole host (RFC 9309 section 2.3.1.3)
+func loadRobots(context *CrawlerContext, rawURL string) *Robots {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	robotsURL := &url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/robots.txt",
+	}
+
+	response, err := context.fetch(robotsURL.String())
+	if err != nil {
+		return nil
+	}
+	defer closeBody(response)
+
+	if response.StatusCode >= 500 {
+		return &Robots{Rules: []RobotsRule{{Allow: false, Pattern: "/"}}}
+	}
+
+	if response.StatusCode >= 300 || response.Body == nil {
+		return nil
+	}
+
+	robots, err := ParseRobots(response.Body, context.Options.UserAgent)
+	if err != nil {
+		return nil
+	}
+
+	return robots
+}
+
+// loadSitemaps retrieves the sitemaps of the site, returning the addresses of the pages in
+// the scope of the crawl without duplicates. The /sitemap.xml of the start URL host is always
+// retrieved, together with the informed sitemaps. The sitemaps referenced by sitemap indexes
+// are also retrieved, up to maxSitemapFiles files. Sitemaps that don't exist or can't be read
+// are ignored
+func loadSitemaps(context *CrawlerContext, rawURL string, sitemaps []string) []string {
+	if u, err := url.Parse(rawURL); err == nil {
+		sitemapURL := &url.URL{
+			Scheme: u.Scheme,
+			Host:   u.Host,
+			Path:   "/sitemap.xml",
+		}
+		sitemaps = append([]string{sitemapURL.String()}, sitemaps...)
+	}
+
+	var urls []string
+	found := make(map[string]bool)
+	loaded := make(map[string]bool)
+
+	for len(sitemaps) > 0 && len(loaded) < maxSitemapFiles && context.Err() == nil {
+		sitemapURL := sitemaps[0]
+		sitemaps = sitemaps[1:]
+
+		// Sitemap indexes could reference each other, so each file is retrieved only once
+		if loaded[normalizeURL(context.Normalizer, sitemapURL)] {
+			continue
+		}
+		loaded[normalizeURL(context.Normalizer, sitemapURL)] = true
+
+		entries, indexed := loadSitemap(context, sitemapURL)
+		for _, sitemap := range indexed {
+			sitemaps = append(sitemaps, sitemap.Loc)
+		}
+
+		for _, entry := range entries {
+			key := normalizeURL(context.Normalizer, entry.Loc)
+			if found[key] || !isHTTP(entry.Loc) || !context.Scope.Contains(entry.Loc) {
+				continue
+			}
+
+			found[key] = true
+			urls = append(urls, entry.Loc)
+		}
+	}
+
+	return urls
+}
+
+// loadSitemap retrieves a single sitemap file, returning the entries of the pages or, for a
+// sitemap index, of the other sitemap files. Relative addresses are resolved against the
+// address of the file
+func loadSitemap(context *CrawlerContext, sitemapURL string) ([]SitemapURL, []SitemapURL) {
+	if !context.waitCrawlDelay(sitemapURL) {
+		return nil, nil
+	}
+
+	response, err := context.fetch(sitemapURL)
+	if err != nil {
+		return nil, nil
+	}
+	defer closeBody(response)
+
+	if response.StatusCode >= 300 || response.Body == nil {
+		return nil, nil
+	}
+
+	entries, sitemaps, err := ParseSitemap(response.Body)
+	if err != nil {
+		return nil, nil
 	}
 
-	context := NewCrawlerContext(url, fetcher)
+	if len(response.URL) > 0 {
+		sitemapURL = response.URL
+	}
 
-	context.WG.Add(1)
-	go crawlPage(context, page)
-	context.WG.Wait()
+	base, err := url.Parse(sitemapURL)
+	if err != nil {
+		return nil, nil
+	}
+
+	for i := range entries {
+		entries[i].Loc = resolveURL(base, entries[i].Loc)
+	}
+	for i := range sitemaps {
+		sitemaps[i].Loc = resolveURL(base, sitemaps[i].Loc)
+	}
 
-	return page, nil
+	return entries, sitemaps
+}
+
+// seedPage schedules the crawl of a start page, or of a page found in the sitemaps, when the
+// limits and rules of the crawl allow it. When the page was already visited the link is marked
+// as cyclic
+func seedPage(context *CrawlerContext, rawURL string, sitemap bool) Link {
+	link := Link{
+		Label:   "<start page>",
+		Href:    rawURL,
+		Sitemap: sitemap,
+		Page: &Page{
+			URL: rawURL,
+		},
+	}
+
+	if sitemap {
+		link.Label = "<sitemap>"
+	}
+
+	if !context.robotsAllowed(rawURL) {
+		link.Page.Skipped = SkipRobots
+
+	} else if visitedPage, visited := context.VisitPage(link.Page); visited {
+		link.Page = visitedPage
+		link.CyclicPage = true
+
+	} else if !context.reservePage() {
+		link.Page.Skipped = SkipMaxPages
+
+	} else if context.Err() != nil {
+		link.Page.Skipped = SkipCancelled
+
+	} else {
+		context.schedule(link.Page)
+	}
+
+	return link
+}
+
+// crawlLevels crawls the scheduled pages one depth level at a time, waiting for all pages of
+// a level before crawling the pages found in them. So a page is always reached first on the
+// shortest path from the start pages, and its depth doesn't depend on which go routine was
+// faster
+func crawlLevels(context *CrawlerContext) {
+	for pages := context.nextLevel(); len(pages) > 0; pages = context.nextLevel() {
+		for _, page := range pages {
+			context.WG.Add(1)
+			go crawlPage(context, page)
+		}
+		context.WG.Wait()
+	}
 }
 
 // Crawl fetch the URL data and try to retrieve all the information from the page,
 // filling the page pointer on successful return
 func crawlPage(context *CrawlerContext, page *Page) {
-	<-sem
+	defer context.WG.Done()
 
-	defer func() {
-		sem <- 1
-		context.WG.Done()
-	}()
+	if !context.waitCrawlDelay(page.URL) || !context.acquire() {
+		page.Skipped = SkipCancelled
+		return
+	}
+	defer context.release()
 
-	context.VisitPage(page)
+	response, err := context.fetch(page.URL)
+	if err != nil {
+		page.setError(err)
+		return
+	}
+	defer closeBody(response)
+
+	page.setResponse(response, context.Options.Headers)
+	if page.Fail {
+		return
+	}
 
-	r, err := context.Fetcher.Fetch(page.URL)
+	directives := ParseXRobotsTag(response.Header.Values("X-Robots-Tag"), context.Options.UserAgent)
+	page.Noindex = directives.Noindex
+	page.Nofollow = directives.Nofollow
+
+	// Pages are identified by the address where the content was really found, so different
+	// addresses that redirect to the same page are analyzed only once
+	if _, duplicated := context.VisitFinalURL(page); duplicated {
+		page.Skipped = SkipDuplicate
+		return
+	}
+
+	// The content of other sites isn't analyzed, even when it's reached by a redirect of a page
+	// in the scope of the crawl (see RedirectChains)
+	if len(page.FinalURL) > 0 && !context.Scope.Contains(page.FinalURL) {
+		return
+	}
+
+	// Only the headers were retrieved until now, so documents like PDFs and images are never
+	// downloaded
+	if !isHTML(page.ContentType) {
+		page.Document = true
+		return
+	}
+
+	body := newBodyReader(response.Body, context.Options.MaxBodySize)
+	root, err := html.Parse(body)
 	if err != nil {
-		page.Fail = true
+		page.setError(err)
 		return
 	}
 
-	root, err := html.Parse(r)
+	if page.ContentLength < 0 {
+		page.ContentLength = body.read
+	}
+
+	// Relative references must be resolved against the address where the content was really
+	// found, and not the one that was requested
+	pageURL := page.URL
+	if len(page.FinalURL) > 0 {
+		pageURL = page.FinalURL
+	}
+
+	// The directives of the document must be known before analyzing the links
+	directives = findMetaRobots(root, context.Options.UserAgent)
+	page.Noindex = page.Noindex || directives.Noindex
+	page.Nofollow = page.Nofollow || directives.Nofollow
+
+	base := baseURL(root, pageURL)
+	if href, found := findCanonical(root); found {
+		page.Canonical = resolveURL(base, href)
+	}
+
+	// Pages of other sites can't be the canonical version of a page of the site, so they are
+	// never registered as visited
+	if context.Options.DedupeCanonical && len(page.Canonical) > 0 &&
+		context.Scope.Contains(page.Canonical) {
+
+		if _, duplicated := context.VisitCanonical(page); duplicated {
+			page.Skipped = SkipCanonical
+			return
+		}
+	}
+
+	parseHTML(context, root, page, base)
+
+	page.AssetChecks = followAssets(context, page.StaticAssets)
+}
+
+// followAssets checks the availability of the static assets and analyzes the stylesheets of
+// the site, according to the crawl options. The resources are returned indexed by the asset
+// URL, and each resource is handled only once during the crawl
+func followAssets(context *CrawlerContext, assets []Asset) map[string]*Resource {
+	var resources map[string]*Resource
+
+	for _, asset := range assets {
+		if !isHTTP(asset.URL) {
+			continue
+		}
+
+		stylesheet := context.Options.CrawlStylesheets && asset.Kind == AssetStylesheet &&
+			context.Scope.Contains(asset.URL) && context.robotsAllowed(asset.URL)
+
+		if !stylesheet && !context.Options.CheckResources {
+			continue
+		}
+
+		resource, created := context.Resource(asset.URL)
+		if resources == nil {
+			resources = make(map[string]*Resource)
+		}
+		resources[asset.URL] = resource
+
+		if !created {
+			continue
+		}
+
+		context.WG.Add(1)
+		if stylesheet {
+			go crawlStylesheet(context, resource)
+		} else {
+			go checkResource(context, resource)
+		}
+	}
+
+	return resources
+}
+
+// checkPage verifies if a page outside the scope of the crawl is available, without analyzing
+// its content
+func checkPage(context *CrawlerContext, page *Page) {
+	defer context.WG.Done()
+
+	if !context.acquire() {
+		page.Skipped = SkipCancelled
+		return
+	}
+	defer context.release()
+
+	response, err := context.check(page.URL)
 	if err != nil {
-		page.Fail = true
+		page.setError(err)
 		return
 	}
 
-	parseHTML(context, root, page)
+	page.setResponse(response, context.Options.Headers)
+}
+
+// checkResource verifies if a static asset is available
+func checkResource(context *CrawlerContext, resource *Resource) {
+	defer context.WG.Done()
+
+	if !context.acquire() {
+		return
+	}
+	defer context.release()
+
+	response, err := context.check(resource.URL)
+	if err != nil {
+		resource.Fail = true
+		resource.Error = err.Error()
+		return
+	}
+
+	resource.setResponse(response)
+}
+
+// followLink fills the page of the link found in the page, scheduling the crawl of the target
+// page in the next depth level when it's in the scope and the limits and rules of the crawl
+// allow it (see crawlLevels)
+func followLink(context *CrawlerContext, page *Page, link *Link, linkURL string) {
+	// Check if we already processed this page, to avoid a cyclic recursion when showing the
+	// results we aren't going to add a reference for the already analyzed page
+	if visitedPage, visited := context.URLWasVisited(linkURL); visited {
+		link.Page = visitedPage
+		link.CyclicPage = true
+
+	} else {
+		link.Page = &Page{
+			URL:   linkURL,
+			Depth: page.Depth + 1,
+		}
+
+		if !context.Scope.Contains(linkURL) {
+			// Pages of other sites are never crawled, but they can be checked for availability.
+			// All links to the same external page share the same object
+			link.Page.External = true
+
+			var created bool
+			link.Page, created = context.ExternalPage(link.Page)
+
+			if created && context.Options.CheckResources && isHTTP(linkURL) {
+				context.WG.Add(1)
+				go checkPage(context, link.Page)
+			}
+
+		} else if !context.robotsAllowed(linkURL) {
+			link.Page.Skipped = SkipRobots
+
+		} else if (link.Nofollow || page.Nofollow) && !context.Options.IgnoreNofollow {
+			// The page isn't registered as visited, because it could be found later by a link
+			// that can be followed
+			link.Page.Skipped = SkipNofollow
+
+		} else if context.exceedsDepth(link.Page.Depth) {
+			// Pages are crawled by depth level, so the page can't be found later on a shorter
+			// path from the start pages
+			link.Page.Skipped = SkipMaxDepth
+
+		} else if visitedPage, visited := context.VisitPage(link.Page); visited {
+			// Another go routine could visit the same page between the check above and now, so
+			// we only crawl the page if we were the first to register it
+			link.Page = visitedPage
+			link.CyclicPage = true
+
+		} else if !context.reservePage() {
+			link.Page.Skipped = SkipMaxPages
+
+		} else if context.Err() != nil {
+			link.Page.Skipped = SkipCancelled
+
+		} else {
+			context.schedule(link.Page)
+		}
+	}
+}
+
+// crawlStylesheet retrieves a stylesheet of the site, storing the fonts, images and other
+// stylesheets that it references. The references are resolved against the stylesheet address
+// and followed in the same way as the static assets of a page
+func crawlStylesheet(context *CrawlerContext, resource *Resource) {
+	defer context.WG.Done()
+
+	if !context.waitCrawlDelay(resource.URL) || !context.acquire() {
+		return
+	}
+	defer context.release()
+
+	response, err := context.fetch(resource.URL)
+	if err != nil {
+		resource.Fail = true
+		resource.Error = err.Error()
+		return
+	}
+	defer closeBody(response)
+
+	resource.setResponse(response)
+	if resource.Fail || response.Body == nil {
+		return
+	}
+
+	content, err := ioutil.ReadAll(newBodyReader(response.Body, context.Options.MaxBodySize))
+	if err != nil {
+		resource.Fail = true
+		resource.Error = err.Error()
+		return
+	}
+	resource.Stylesheet = true
+
+	stylesheetURL := resource.URL
+	if len(response.URL) > 0 {
+		stylesheetURL = response.URL
+	}
+
+	base, err := url.Parse(stylesheetURL)
+	if err != nil {
+		return
+	}
+
+	for _, reference := range parseCSSURLs(string(content)) {
+		rawURL, ok := assetReference(reference.url)
+		if !ok {
+			continue
+		}
+
+		resource.StaticAssets = addAsset(resource.StaticAssets, Asset{
+			URL:  resolveURL(base, rawURL),
+			Raw:  rawURL,
+			Kind: cssReferenceKind(reference),
+		})
+	}
+
+	resource.AssetChecks = followAssets(context, resource.StaticAssets)
 }
 
 // parseHTML is an auxiliary function of Crawl function that will travel recursively
-// around the HTML document identifying elements to populate the Page object
-func parseHTML(context *CrawlerContext, node *html.Node, page *Page) {
+// around the HTML document identifying elements to populate the Page object. All references
+// found are resolved against the base URL
+func parseHTML(context *CrawlerContext, node *html.Node, page *Page, base *url.URL) {
 	if node.Type == html.ElementNode {
 		switch node.Data {
 		case "a":
 			var link Link
+			if rel, found := attribute(node, "rel"); found {
+				for _, relType := range strings.Fields(strings.ToLower(rel)) {
+					if relType == "nofollow" {
+						link.Nofollow = true
+					}
+				}
+			}
+
 			for _, attr := range node.Attr {
 				if attr.Key != "href" {
 					continue
 				}
 
-				linkURL := strings.TrimSpace(attr.Val)
-				if strings.HasPrefix(linkURL, "/") {
-					linkURL = context.Domain + linkURL
-				}
-
-				// Check if we already processed this page, to avoid a cyclic recursion when
-				// showing the results we aren't going to add a reference for the already analyzed
-				// page
-				if page, visited := context.URLWasVisited(linkURL); visited {
-					link.Page = page
-					link.CyclicPage = true
-
-				} else {
-					link.Page = &Page{
-						URL: linkURL,
-					}
+				link.Href = attr.Val
+				linkURL := resolveURL(base, attr.Val)
 
-					if strings.HasPrefix(linkURL, context.Domain) {
-						context.WG.Add(1)
-						go crawlPage(context, link.Page)
-					}
-				}
-
-				// TODO: Not checking when the link has a relative path
+				followLink(context, page, &link, linkURL)
 				break
 			}
 
@@ -136,23 +649,34 @@ func parseHTML(context *CrawlerContext, node *html.Node, page *Page) {
 
 			page.Links = append(page.Links, link)
 
-		case "link":
-			for _, attr := range node.Attr {
-				if attr.Key == "href" {
-					page.StaticAssets = append(page.StaticAssets, attr.Val)
-				}
+		case "meta":
+			// A refresh with a target address works as a redirect, so the target is followed
+			// like any other link
+			httpEquiv, _ := attribute(node, "http-equiv")
+			if !strings.EqualFold(strings.TrimSpace(httpEquiv), "refresh") {
+				break
 			}
 
-		case "img", "script":
-			for _, attr := range node.Attr {
-				if attr.Key == "src" {
-					page.StaticAssets = append(page.StaticAssets, attr.Val)
-				}
+			content, _ := attribute(node, "content")
+			delay, target, ok := parseRefresh(content)
+			if !ok || len(target) == 0 {
+				break
+			}
+
+			link := Link{
+				Label:        "<meta refresh>",
+				Href:         target,
+				Refresh:      true,
+				RefreshDelay: delay,
 			}
+			followLink(context, page, &link, resolveURL(base, target))
+			page.Links = append(page.Links, link)
 		}
+
+		parseAssets(node, page, base)
 	}
 
 	for child := node.FirstChild; child != nil; child = child.NextSibling {
-		parseHTML(context, child, page)
+		parseHTML(context, child, page, base)
 	}
 }
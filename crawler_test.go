@@ -5,23 +5,92 @@
 package crawler
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // FakeFetcher is a function that implements an interface using the same strategy of
 // http.HandlerFunc. http://www.onebigfluke.com/2014/04/gos-power-is-in-emergent-behavior.html
-type FakeFetcher func(url string) (io.Reader, error)
+type FakeFetcher func(url string) (*Response, error)
 
-func (f FakeFetcher) Fetch(url string) (io.Reader, error) {
+func (f FakeFetcher) Fetch(url string) (*Response, error) {
 	return f(url)
 }
 
+// FakeContextFetcher is a function that implements the ContextFetcher interface, to simulate
+// responses that depend on the crawl cancellation
+type FakeContextFetcher func(ctx context.Context, url string) (*Response, error)
+
+func (f FakeContextFetcher) Fetch(url string) (*Response, error) {
+	return f(context.Background(), url)
+}
+
+func (f FakeContextFetcher) FetchContext(ctx context.Context, url string) (*Response, error) {
+	return f(ctx, url)
+}
+
+// FakeCheckerFetcher is a FakeFetcher that also implements the Checker interface
+type FakeCheckerFetcher struct {
+	FakeFetcher
+	check func(url string) (*Response, error)
+}
+
+func (f FakeCheckerFetcher) Check(ctx context.Context, url string) (*Response, error) {
+	return f.check(url)
+}
+
+// htmlResponse builds a successful response with the HTML content
+func htmlResponse(url, data string) *Response {
+	return &Response{
+		URL:           url,
+		StatusCode:    http.StatusOK,
+		ContentType:   "text/html; charset=utf-8",
+		ContentLength: int64(len(data)),
+		Body:          strings.NewReader(data),
+	}
+}
+
+// exampleAssets builds the static assets of the test pages that reference a stylesheet, an
+// image and a script with the same name
+func exampleAssets(name string) []Asset {
+	return []Asset{
+		{
+			URL:       "http://example.com/" + name + ".css",
+			Raw:       name + ".css",
+			Kind:      AssetStylesheet,
+			Tag:       "link",
+			Attribute: "href",
+			Rel:       "stylesheet",
+			Type:      "text/css",
+		},
+		{
+			URL:       "http://example.com/" + name + ".png",
+			Raw:       name + ".png",
+			Kind:      AssetImage,
+			Tag:       "img",
+			Attribute: "src",
+		},
+		{
+			URL:       "http://example.com/" + name + ".js",
+			Raw:       name + ".js",
+			Kind:      AssetScript,
+			Tag:       "script",
+			Attribute: "src",
+			Type:      "text/javascript",
+		},
+	}
+}
+
 func TestCrawlMustReturnPageWithInformation(t *testing.T) {
 	testData := []struct {
 		url      string
@@ -30,94 +99,82 @@ func TestCrawlMustReturnPageWithInformation(t *testing.T) {
 	}{
 		// Lower case test
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.net">Example</a>
+    <a href="http://example.net">Example</a>
     <img src="example.png" alt="example"/>
     <script type="text/javascript" src="example.js"/>
   </body>
 </html>`,
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Example",
-						Page:  &Page{URL: "example.net"},
+						Page:  &Page{URL: "http://example.net"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// Upper case test
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: `<html>
   <head>
     <LINK rel="stylesheet" type="text/css" HREF="example.css">
   </head>
   <body>
-    <A HREF="example.net">Example</A>
+    <A HREF="http://example.net">Example</A>
     <IMG SRC="example.png" alt="example"/>
     <SCRIPT type="text/javascript" SRC="example.js"/>
   </body>
 </html>`,
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Example",
-						Page:  &Page{URL: "example.net"},
+						Page:  &Page{URL: "http://example.net"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// No end-tag test
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.net">Example
+    <a href="http://example.net">Example
     <img src="example.png" alt="example">
     <script type="text/javascript" src="example.js">
   </body>
 </html>`,
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Example",
-						Page:  &Page{URL: "example.net"},
+						Page:  &Page{URL: "http://example.net"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// No href test
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
@@ -129,83 +186,71 @@ func TestCrawlMustReturnPageWithInformation(t *testing.T) {
   </body>
 </html>`,
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Example",
 						Page:  nil,
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// Compose label test
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.net">Example<span>Test</span>Link</a>
+    <a href="http://example.net">Example<span>Test</span>Link</a>
     <img src="example.png" alt="example">
     <script type="text/javascript" src="example.js">
   </body>
 </html>`,
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Example\nLink",
-						Page:  &Page{URL: "example.net"},
+						Page:  &Page{URL: "http://example.net"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// No label test
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.net"></a>
+    <a href="http://example.net"></a>
     <img src="example.png" alt="example">
     <script type="text/javascript" src="example.js">
   </body>
 </html>`,
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "<no label>",
-						Page:  &Page{URL: "example.net"},
+						Page:  &Page{URL: "http://example.net"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 	}
 
 	for _, testItem := range testData {
-		page, err := Crawl(testItem.url, FakeFetcher(func(url string) (io.Reader, error) {
-			return strings.NewReader(testItem.data), nil
+		page, err := Crawl(testItem.url, FakeFetcher(func(url string) (*Response, error) {
+			return htmlResponse(url, testItem.data), nil
 		}))
 
 		if err != nil {
@@ -226,18 +271,18 @@ func TestCrawlMustReturnFailPageOnFetchProblems(t *testing.T) {
 		expected Page
 	}{
 		{
-			url:  "example.com",
+			url:  "http://example.com",
 			data: "",
 			expected: Page{
-				URL:  "example.com",
+				URL:  "http://example.com",
 				Fail: true,
 			},
 		},
 	}
 
 	for _, testItem := range testData {
-		page, err := Crawl(testItem.url, FakeFetcher(func(url string) (io.Reader, error) {
-			return strings.NewReader(testItem.data), http.ErrContentLength
+		page, err := Crawl(testItem.url, FakeFetcher(func(url string) (*Response, error) {
+			return htmlResponse(url, testItem.data), http.ErrContentLength
 		}))
 
 		if err != nil {
@@ -251,6 +296,20 @@ func TestCrawlMustReturnFailPageOnFetchProblems(t *testing.T) {
 	}
 }
 
+func TestCrawlMustRejectInvalidURL(t *testing.T) {
+	page, err := Crawl("example.com", FakeFetcher(func(url string) (*Response, error) {
+		return htmlResponse(url, ""), nil
+	}))
+
+	if err != ErrInvalidURL {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'", ErrInvalidURL, err)
+	}
+
+	if page != nil {
+		t.Errorf("Unexpected page returned. Expected nil and got '%s'", page)
+	}
+}
+
 func TestCrawlMustFollowLinks(t *testing.T) {
 	testData := []struct {
 		url      string
@@ -259,19 +318,19 @@ func TestCrawlMustFollowLinks(t *testing.T) {
 	}{
 		// One level link
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: map[string]string{
-				"example.com": `<html>
+				"http://example.com": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.com/link1.html">Link 1</a>
+    <a href="http://example.com/link1.html">Link 1</a>
     <img src="example.png" alt="example"/>
     <script type="text/javascript" src="example.js"/>
   </body>
 </html>`,
-				"example.com/link1.html": `<html>
+				"http://example.com/link1.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link1.css">
   </head>
@@ -282,33 +341,25 @@ func TestCrawlMustFollowLinks(t *testing.T) {
 </html>`,
 			},
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Link 1",
 						Page: &Page{
-							URL: "example.com/link1.html",
-							StaticAssets: []string{
-								"link1.css",
-								"link1.png",
-								"link1.js",
-							},
+							URL:          "http://example.com/link1.html",
+							StaticAssets: exampleAssets("link1"),
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// One level link with partial link
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: map[string]string{
-				"example.com": `<html>
+				"http://example.com": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
@@ -318,7 +369,7 @@ func TestCrawlMustFollowLinks(t *testing.T) {
     <script type="text/javascript" src="example.js"/>
   </body>
 </html>`,
-				"example.com/link1.html": `<html>
+				"http://example.com/link1.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link1.css">
   </head>
@@ -329,53 +380,45 @@ func TestCrawlMustFollowLinks(t *testing.T) {
 </html>`,
 			},
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Link 1",
 						Page: &Page{
-							URL: "example.com/link1.html",
-							StaticAssets: []string{
-								"link1.css",
-								"link1.png",
-								"link1.js",
-							},
+							URL:          "http://example.com/link1.html",
+							StaticAssets: exampleAssets("link1"),
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// Two levels link
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: map[string]string{
-				"example.com": `<html>
+				"http://example.com": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.com/link1.html">Link 1</a>
+    <a href="http://example.com/link1.html">Link 1</a>
     <img src="example.png" alt="example"/>
     <script type="text/javascript" src="example.js"/>
   </body>
 </html>`,
-				"example.com/link1.html": `<html>
+				"http://example.com/link1.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link1.css">
   </head>
   <body>
-    <a href="example.com/link2.html">Link 2</a>
+    <a href="http://example.com/link2.html">Link 2</a>
     <img src="link1.png" alt="link1"/>
     <script type="text/javascript" src="link1.js"/>
   </body>
 </html>`,
-				"example.com/link2.html": `<html>
+				"http://example.com/link2.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link2.css">
   </head>
@@ -386,136 +429,161 @@ func TestCrawlMustFollowLinks(t *testing.T) {
 </html>`,
 			},
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Link 1",
 						Page: &Page{
-							URL: "example.com/link1.html",
+							URL: "http://example.com/link1.html",
 							Links: []Link{
 								{
 									Label: "Link 2",
 									Page: &Page{
-										URL: "example.com/link2.html",
-										StaticAssets: []string{
-											"link2.css",
-											"link2.png",
-											"link2.js",
-										},
+										URL:          "http://example.com/link2.html",
+										StaticAssets: exampleAssets("link2"),
 									},
 								},
 							},
-							StaticAssets: []string{
-								"link1.css",
-								"link1.png",
-								"link1.js",
-							},
+							StaticAssets: exampleAssets("link1"),
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 
 		// Cyclic link
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: map[string]string{
-				"example.com": `<html>
+				"http://example.com": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="example.com/link1.html">Link 1</a>
+    <a href="http://example.com/link1.html">Link 1</a>
     <img src="example.png" alt="example"/>
     <script type="text/javascript" src="example.js"/>
   </body>
 </html>`,
-				"example.com/link1.html": `<html>
+				"http://example.com/link1.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link1.css">
   </head>
   <body>
-    <a href="example.com/link2.html">Link 2</a>
+    <a href="http://example.com/link2.html">Link 2</a>
     <img src="link1.png" alt="link1"/>
     <script type="text/javascript" src="link1.js"/>
   </body>
 </html>`,
-				"example.com/link2.html": `<html>
+				"http://example.com/link2.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link2.css">
   </head>
   <body>
-    <a href="example.com">Example</a>
+    <a href="http://example.com">Example</a>
     <img src="link2.png" alt="link2"/>
     <script type="text/javascript" src="link2.js"/>
   </body>
 </html>`,
 			},
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Link 1",
 						Page: &Page{
-							URL: "example.com/link1.html",
+							URL: "http://example.com/link1.html",
 							Links: []Link{
 								{
 									Label: "Link 2",
 									Page: &Page{
-										URL: "example.com/link2.html",
+										URL: "http://example.com/link2.html",
 										Links: []Link{
 											{
 												Label:      "Example",
 												CyclicPage: true,
 												Page: &Page{
-													URL: "example.com",
+													URL: "http://example.com",
 												},
 											},
 										},
-										StaticAssets: []string{
-											"link2.css",
-											"link2.png",
-											"link2.js",
-										},
+										StaticAssets: exampleAssets("link2"),
 									},
 								},
 							},
-							StaticAssets: []string{
-								"link1.css",
-								"link1.png",
-								"link1.js",
-							},
+							StaticAssets: exampleAssets("link1"),
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
+				StaticAssets: exampleAssets("example"),
+			},
+		},
+
+		// Link on look-alike domain
+		{
+			url: "http://example.com",
+			data: map[string]string{
+				"http://example.com": `<html>
+  <body>
+    <a href="http://example.com.evil.net/link1.html">Link 1</a>
+    <a href="https://example.com/link2.html">Link 2</a>
+  </body>
+</html>`,
+				"http://example.com.evil.net/link1.html": `<html>
+  <body>
+    <img src="link1.png" alt="link1"/>
+  </body>
+</html>`,
+				"https://example.com/link2.html": `<html>
+  <body>
+    <img src="link2.png" alt="link2"/>
+  </body>
+</html>`,
+			},
+			expected: Page{
+				URL: "http://example.com",
+				Links: []Link{
+					{
+						Label: "Link 1",
+						Page: &Page{
+							URL: "http://example.com.evil.net/link1.html",
+						},
+					},
+					{
+						Label: "Link 2",
+						Page: &Page{
+							URL: "https://example.com/link2.html",
+							StaticAssets: []Asset{
+								{
+									URL:       "https://example.com/link2.png",
+									Raw:       "link2.png",
+									Kind:      AssetImage,
+									Tag:       "img",
+									Attribute: "src",
+								},
+							},
+						},
+					},
 				},
 			},
 		},
 
 		// Link on subdomain
 		{
-			url: "example.com",
+			url: "http://example.com",
 			data: map[string]string{
-				"example.com": `<html>
+				"http://example.com": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="example.css">
   </head>
   <body>
-    <a href="test.example.com/link1.html">Link 1</a>
+    <a href="http://test.example.com/link1.html">Link 1</a>
     <img src="example.png" alt="example"/>
     <script type="text/javascript" src="example.js"/>
   </body>
 </html>`,
-				"test.example.com/link1.html": `<html>
+				"http://test.example.com/link1.html": `<html>
   <head>
     <link rel="stylesheet" type="text/css" href="link1.css">
   </head>
@@ -526,27 +594,23 @@ func TestCrawlMustFollowLinks(t *testing.T) {
 </html>`,
 			},
 			expected: Page{
-				URL: "example.com",
+				URL: "http://example.com",
 				Links: []Link{
 					{
 						Label: "Link 1",
 						Page: &Page{
-							URL: "test.example.com/link1.html",
+							URL: "http://test.example.com/link1.html",
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.png",
-					"example.js",
-				},
+				StaticAssets: exampleAssets("example"),
 			},
 		},
 	}
 
 	for _, testItem := range testData {
-		page, err := Crawl(testItem.url, FakeFetcher(func(url string) (io.Reader, error) {
-			return strings.NewReader(testItem.data[url]), nil
+		page, err := Crawl(testItem.url, FakeFetcher(func(url string) (*Response, error) {
+			return htmlResponse(url, testItem.data[url]), nil
 		}))
 
 		if err != nil {
@@ -560,6 +624,1519 @@ func TestCrawlMustFollowLinks(t *testing.T) {
 	}
 }
 
+func TestCrawlMustResolveRelativeReferences(t *testing.T) {
+	data := map[string]string{
+		"http://example.com/docs/": `<html>
+  <head>
+    <link rel="stylesheet" type="text/css" href="../css/docs.css">
+  </head>
+  <body>
+    <a href="page2.html">Page 2</a>
+    <a href="?page=3">Page 3</a>
+    <a href="//example.net/x">External</a>
+    <img src="img/logo.png" alt="logo"/>
+  </body>
+</html>`,
+		"http://example.com/docs/page2.html": `<html>
+  <head>
+    <base href="/static/">
+  </head>
+  <body>
+    <a href="/docs/">Index</a>
+    <script type="text/javascript" src="page2.js"/>
+  </body>
+</html>`,
+		"http://example.com/docs/?page=3": `<html><body></body></html>`,
+	}
+
+	expected := Page{
+		URL: "http://example.com/docs/",
+		Links: []Link{
+			{
+				Label: "Page 2",
+				Page: &Page{
+					URL: "http://example.com/docs/page2.html",
+					Links: []Link{
+						{
+							Label:      "Index",
+							CyclicPage: true,
+							Page:       &Page{URL: "http://example.com/docs/"},
+						},
+					},
+					StaticAssets: []Asset{
+						{
+							URL:       "http://example.com/static/page2.js",
+							Raw:       "page2.js",
+							Kind:      AssetScript,
+							Tag:       "script",
+							Attribute: "src",
+							Type:      "text/javascript",
+						},
+					},
+				},
+			},
+			{
+				Label: "Page 3",
+				Page:  &Page{URL: "http://example.com/docs/?page=3"},
+			},
+			{
+				Label: "External",
+				Page:  &Page{URL: "http://example.net/x"},
+			},
+		},
+		StaticAssets: []Asset{
+			{
+				URL:       "http://example.com/css/docs.css",
+				Raw:       "../css/docs.css",
+				Kind:      AssetStylesheet,
+				Tag:       "link",
+				Attribute: "href",
+				Rel:       "stylesheet",
+				Type:      "text/css",
+			},
+			{
+				URL:       "http://example.com/docs/img/logo.png",
+				Raw:       "img/logo.png",
+				Kind:      AssetImage,
+				Tag:       "img",
+				Attribute: "src",
+			},
+		},
+	}
+
+	page, err := Crawl("http://example.com/docs/", FakeFetcher(func(url string) (*Response, error) {
+		return htmlResponse(url, data[url]), nil
+	}))
+
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if !page.Equal(expected) {
+		t.Errorf("Unexpected page returned. Expected '%s' and got '%s'", expected, page)
+	}
+}
+
+func TestCrawlMustDetectEquivalentURLs(t *testing.T) {
+	var fetches []string
+	var fetchesLock sync.Mutex
+
+	data := map[string]string{
+		"http://example.com": `<html>
+  <body>
+    <a href="http://example.com/">Home</a>
+    <a href="http://EXAMPLE.com/#top">Top</a>
+    <a href="/index.html?utm_source=x">Index</a>
+    <a href="/docs/../about.html">About</a>
+  </body>
+</html>`,
+		"http://example.com/about.html": `<html>
+  <body>
+    <a href="/about.html#team">Team</a>
+  </body>
+</html>`,
+	}
+
+	expected := Page{
+		URL: "http://example.com",
+		Links: []Link{
+			{
+				Label:      "Home",
+				CyclicPage: true,
+				Page:       &Page{URL: "http://example.com"},
+			},
+			{
+				Label:      "Top",
+				CyclicPage: true,
+				Page:       &Page{URL: "http://example.com"},
+			},
+			{
+				Label:      "Index",
+				CyclicPage: true,
+				Page:       &Page{URL: "http://example.com"},
+			},
+			{
+				Label: "About",
+				Page: &Page{
+					URL: "http://example.com/about.html",
+					Links: []Link{
+						{
+							Label:      "Team",
+							CyclicPage: true,
+							Page:       &Page{URL: "http://example.com/about.html"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	page, err := Crawl("http://example.com", FakeFetcher(func(url string) (*Response, error) {
+		// Only the fetches of pages are counted
+		if url == "http://example.com/robots.txt" {
+			return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+		}
+
+		fetchesLock.Lock()
+		fetches = append(fetches, url)
+		fetchesLock.Unlock()
+		return htmlResponse(url, data[url]), nil
+	}))
+
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if !page.Equal(expected) {
+		t.Errorf("Unexpected page returned. Expected '%s' and got '%s'", expected, page)
+	}
+
+	if len(fetches) != 2 {
+		t.Errorf("Unexpected number of fetches. Expected 2 and got %d: %v", len(fetches), fetches)
+	}
+
+	if page.Links[2].Href != "/index.html?utm_source=x" {
+		t.Errorf("Original href not stored in the link. Expected '%s' and got '%s'",
+			"/index.html?utm_source=x", page.Links[2].Href)
+	}
+}
+
+func TestCrawlWithOptionsMustRespectLimits(t *testing.T) {
+	// Each page links to the next two pages, creating a binary tree of pages
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		var id int
+		fmt.Sscanf(url, "http://example.com/%d", &id)
+		return htmlResponse(url, fmt.Sprintf(`<html><body>
+  <a href="/%d">Left</a>
+  <a href="/%d">Right</a>
+</body></html>`, id*2+1, id*2+2)), nil
+	})
+
+	testData := []struct {
+		description string
+		options     CrawlOptions
+		expected    int
+		expectedErr error
+	}{
+		{
+			description: "maximum depth",
+			options:     CrawlOptions{MaxDepth: 2},
+			expected:    7,
+		},
+		{
+			description: "maximum pages",
+			options:     CrawlOptions{MaxDepth: 5, MaxPages: 10},
+			expected:    10,
+		},
+		{
+			description: "timeout",
+			options:     CrawlOptions{Timeout: time.Nanosecond},
+			expected:    0,
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, testItem := range testData {
+		page, err := CrawlWithOptions("http://example.com/0", fetcher, testItem.options)
+		if err != testItem.expectedErr {
+			t.Fatalf("Unexpected error returned on %s test. Expected '%v' and got '%v'",
+				testItem.description, testItem.expectedErr, err)
+		}
+
+		if crawled := countCrawledPages(page, make(map[*Page]bool)); crawled != testItem.expected {
+			t.Errorf("Unexpected number of crawled pages on %s test. Expected %d and got %d",
+				testItem.description, testItem.expected, crawled)
+		}
+	}
+}
+
+func TestCrawlWithOptionsMustRespectConcurrency(t *testing.T) {
+	var running, maxRunning int
+	var lock sync.Mutex
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		if url != "http://example.com" {
+			return htmlResponse(url, "<html><body></body></html>"), nil
+		}
+
+		links := ""
+		for i := 0; i < 50; i++ {
+			links += fmt.Sprintf("<a href=\"/test%d.html\">Test %d</a>\n", i, i)
+		}
+		return htmlResponse(url, fmt.Sprintf("<html><body>%s</body></html>", links)), nil
+	})
+
+	options := DefaultCrawlOptions()
+	options.MaxConcurrency = 3
+
+	// Run two crawls at the same time to make sure that they don't share the same limit
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := CrawlWithOptions("http://example.com", fetcher, options); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > 6 {
+		t.Errorf("Too many concurrent fetches. Expected at most 6 and got %d", maxRunning)
+	}
+}
+
+// countCrawledPages travels the page tree counting the pages that were fetched. Pages already
+// visited are ignored
+func countCrawledPages(page *Page, visited map[*Page]bool) int {
+	if page == nil || visited[page] {
+		return 0
+	}
+	visited[page] = true
+
+	count := 0
+	if len(page.Links) > 0 {
+		count++
+	}
+
+	for _, link := range page.Links {
+		count += countCrawledPages(link.Page, visited)
+	}
+	return count
+}
+
+func TestCrawlContextMustStopOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fetches int
+	var fetchesLock sync.Mutex
+
+	// Infinite site where each page links to the next one. The crawl is cancelled after some
+	// pages, and the fetcher must receive the cancellation
+	fetcher := FakeContextFetcher(func(ctx context.Context, url string) (*Response, error) {
+		// Only the fetches of pages are counted
+		if url == "http://example.com/robots.txt" {
+			return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+		}
+
+		fetchesLock.Lock()
+		fetches++
+		if fetches == 5 {
+			cancel()
+		}
+		fetchesLock.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var id int
+		fmt.Sscanf(url, "http://example.com/%d", &id)
+		return htmlResponse(url, fmt.Sprintf(`<html><body><a href="/%d">Next</a></body></html>`,
+			id+1)), nil
+	})
+
+	page, err := CrawlContext(ctx, "http://example.com/0", fetcher, DefaultCrawlOptions())
+	if err != context.Canceled {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", context.Canceled, err)
+	}
+
+	if page == nil || page.URL != "http://example.com/0" || len(page.Links) != 1 {
+		t.Fatalf("Partial page tree not returned. Got '%v'", page)
+	}
+
+	if crawled := countCrawledPages(page, make(map[*Page]bool)); crawled != 4 {
+		t.Errorf("Unexpected number of crawled pages. Expected 4 and got %d", crawled)
+	}
+}
+
+func TestCrawlMustUseTheShortestDepth(t *testing.T) {
+	data := map[string]string{
+		"http://example.com": `<html><body>
+  <a href="/a.html">A</a>
+  <a href="/b.html">B</a>
+</body></html>`,
+		"http://example.com/a.html": `<html><body><a href="/c.html">C</a></body></html>`,
+		"http://example.com/b.html": `<html><body><a href="/d.html">D</a></body></html>`,
+		"http://example.com/c.html": `<html><body><a href="/d.html">D</a></body></html>`,
+		"http://example.com/d.html": `<html><body><a href="/e.html">E</a></body></html>`,
+		"http://example.com/e.html": `<html><body></body></html>`,
+	}
+
+	// The shortest path to the page D is found by the slower branch
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		if url == "http://example.com/b.html" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return htmlResponse(url, data[url]), nil
+	})
+
+	options := DefaultCrawlOptions()
+	options.IgnoreRobots = true
+	options.MaxDepth = 3
+
+	page, err := CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	b := page.Links[1].Page
+	if len(b.Links) != 1 || b.Links[0].CyclicPage {
+		t.Fatalf("Page not crawled on the shortest path: %+v", b.Links)
+	}
+
+	d := b.Links[0].Page
+	if d.Depth != 2 {
+		t.Errorf("Unexpected depth. Expected 2 and got %d", d.Depth)
+	}
+
+	if e := d.Links[0].Page; e.Depth != 3 || e.Skipped != "" {
+		t.Errorf("Unexpected depth or skip reason. Expected '3' and '' and got '%d' and '%s'",
+			e.Depth, e.Skipped)
+	}
+
+	c := page.Links[0].Page.Links[0].Page
+	if !c.Links[0].CyclicPage || c.Links[0].Page != d {
+		t.Errorf("Longer path not marked as cyclic: %+v", c.Links[0])
+	}
+}
+
+func TestCrawlMustMarkSkippedPages(t *testing.T) {
+	data := map[string]string{
+		"http://example.com": `<html><body>
+  <a href="/link1.html">Link 1</a>
+  <a href="/link2.html">Link 2</a>
+</body></html>`,
+		"http://example.com/link1.html": `<html><body>
+  <a href="/link3.html">Link 3</a>
+  <a href="http://example.net">External</a>
+</body></html>`,
+		"http://example.com/link2.html": `<html><body></body></html>`,
+	}
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		return htmlResponse(url, data[url]), nil
+	})
+
+	// Maximum depth test
+	page, err := CrawlWithOptions("http://example.com", fetcher, CrawlOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	link1 := page.Links[0].Page
+	if page.Depth != 0 || link1.Depth != 1 || link1.Skipped != "" {
+		t.Errorf("Unexpected depth or skip reason for crawled pages. Got '%d', '%d' and '%s'",
+			page.Depth, link1.Depth, link1.Skipped)
+	}
+
+	if link3 := link1.Links[0].Page; link3.Depth != 2 || link3.Skipped != SkipMaxDepth {
+		t.Errorf("Unexpected depth or skip reason for deep page. Expected '2' and '%s' and got "+
+			"'%d' and '%s'", SkipMaxDepth, link3.Depth, link3.Skipped)
+	}
+
+	if external := link1.Links[1].Page; external.Skipped != "" {
+		t.Errorf("External page marked as skipped with reason '%s'", external.Skipped)
+	}
+
+	// Maximum pages test
+	page, err = CrawlWithOptions("http://example.com", fetcher, CrawlOptions{MaxPages: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	for _, link := range page.Links {
+		if link.Page.Skipped != SkipMaxPages || len(link.Page.Links) > 0 {
+			t.Errorf("Page '%s' not skipped by the maximum pages limit", link.Page.URL)
+		}
+	}
+}
+
+func TestCrawlMustStoreResponseMetadata(t *testing.T) {
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		switch url {
+		case "http://example.com":
+			response := htmlResponse("http://example.com/home/", `<html><body>
+  <a href="about.html">About</a>
+  <a href="/missing.html">Missing</a>
+</body></html>`)
+			response.Header = http.Header{
+				"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+				"X-Internal":    []string{"secret"},
+			}
+			response.Duration = time.Second
+			return response, nil
+
+		case "http://example.com/home/about.html":
+			return htmlResponse(url, "<html><body></body></html>"), nil
+		}
+
+		response := htmlResponse(url, `<html><body><a href="/other.html">Other</a></body></html>`)
+		response.StatusCode = http.StatusNotFound
+		return response, nil
+	})
+
+	page, err := Crawl("http://example.com", fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if page.FinalURL != "http://example.com/home/" ||
+		page.StatusCode != http.StatusOK ||
+		page.ContentType != "text/html; charset=utf-8" ||
+		page.Duration != time.Second ||
+		page.Header.Get("Last-Modified") != "Wed, 21 Oct 2015 07:28:00 GMT" ||
+		page.Header.Get("X-Internal") != "" {
+
+		t.Errorf("Unexpected page metadata: %+v", page)
+	}
+
+	// Relative links must be resolved against the final URL
+	if about := page.Links[0].Page; about.URL != "http://example.com/home/about.html" ||
+		about.Fail {
+		t.Errorf("Unexpected link page: %+v", about)
+	}
+
+	// Error status codes mark the page as failed and the content isn't analyzed
+	if missing := page.Links[1].Page; !missing.Fail ||
+		missing.StatusCode != http.StatusNotFound ||
+		len(missing.Links) > 0 {
+		t.Errorf("Unexpected link page: %+v", missing)
+	}
+}
+
+func TestCrawlMustCheckResources(t *testing.T) {
+	var checks []string
+	var checksLock sync.Mutex
+
+	data := map[string]string{
+		"http://example.com": `<html>
+  <head>
+    <link rel="stylesheet" type="text/css" href="style.css">
+  </head>
+  <body>
+    <a href="/about.html">About</a>
+    <a href="http://example.net/">External</a>
+    <a href="http://example.org/">Broken external</a>
+    <a href="mailto:someone@example.com">Mail</a>
+    <img src="logo.png" alt="logo"/>
+  </body>
+</html>`,
+		"http://example.com/about.html": `<html>
+  <body>
+    <a href="http://example.net">External again</a>
+    <img src="logo.png" alt="logo"/>
+  </body>
+</html>`,
+	}
+
+	fetcher := FakeCheckerFetcher{
+		FakeFetcher: func(url string) (*Response, error) {
+			return htmlResponse(url, data[url]), nil
+		},
+		check: func(url string) (*Response, error) {
+			checksLock.Lock()
+			checks = append(checks, url)
+			checksLock.Unlock()
+
+			switch url {
+			case "http://example.org/":
+				return nil, fmt.Errorf("connection refused")
+			case "http://example.com/logo.png":
+				return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+			}
+			return &Response{URL: url, StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	options := DefaultCrawlOptions()
+	options.CheckResources = true
+
+	page, err := CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	// Each resource must be checked only once
+	sort.Strings(checks)
+	expectedChecks := []string{
+		"http://example.com/logo.png",
+		"http://example.com/style.css",
+		"http://example.net/",
+		"http://example.org/",
+	}
+	if !reflect.DeepEqual(checks, expectedChecks) {
+		t.Errorf("Unexpected checks. Expected '%v' and got '%v'", expectedChecks, checks)
+	}
+
+	external := page.Links[1].Page
+	if !external.External || external.Fail || external.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected external page: %+v", external)
+	}
+
+	if page.Links[0].Page.Links[0].Page != external {
+		t.Error("Links to the same external page aren't sharing the same object")
+	}
+
+	if brokenExternal := page.Links[2].Page; !brokenExternal.Fail ||
+		brokenExternal.Error != "connection refused" {
+		t.Errorf("Unexpected broken external page: %+v", brokenExternal)
+	}
+
+	brokenLinks := BrokenLinks(page)
+	if len(brokenLinks) != 2 ||
+		brokenLinks[0].URL != "http://example.com/logo.png" ||
+		len(brokenLinks[0].References) != 2 ||
+		brokenLinks[1].URL != "http://example.org/" {
+		t.Errorf("Unexpected broken links: %v", brokenLinks)
+	}
+}
+
+func TestCrawlMustRespectRobots(t *testing.T) {
+	var fetches []string
+	var fetchesLock sync.Mutex
+	var fetchTimes []time.Time
+
+	data := map[string]string{
+		"http://example.com/robots.txt": `# Rules of the site
+User-agent: *
+Disallow: /
+
+User-agent: crawler
+Disallow: /private/
+Allow: /private/public.html
+Crawl-delay: 0.05`,
+		"http://example.com": `<html><body>
+  <a href="/about.html">About</a>
+  <a href="/private/secret.html">Secret</a>
+  <a href="/private/public.html">Public</a>
+</body></html>`,
+	}
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		fetchesLock.Lock()
+		fetches = append(fetches, url)
+		if url != "http://example.com/robots.txt" {
+			fetchTimes = append(fetchTimes, time.Now())
+		}
+		fetchesLock.Unlock()
+		return htmlResponse(url, data[url]), nil
+	})
+
+	page, err := Crawl("http://example.com", fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if about := page.Links[0].Page; about.Skipped != "" {
+		t.Errorf("Allowed page skipped with reason '%s'", about.Skipped)
+	}
+
+	if secret := page.Links[1].Page; secret.Skipped != SkipRobots {
+		t.Errorf("Unexpected skip reason for disallowed page. Expected '%s' and got '%s'",
+			SkipRobots, secret.Skipped)
+	}
+
+	if public := page.Links[2].Page; public.Skipped != "" {
+		t.Errorf("Allowed page skipped with reason '%s'", public.Skipped)
+	}
+
+	sort.Strings(fetches)
+	expectedFetches := []string{
+		"http://example.com",
+		"http://example.com/about.html",
+		"http://example.com/private/public.html",
+		"http://example.com/robots.txt",
+	}
+	if !reflect.DeepEqual(fetches, expectedFetches) {
+		t.Errorf("Unexpected fetches. Expected '%v' and got '%v'", expectedFetches, fetches)
+	}
+
+	// The crawl delay must space the fetches of the pages
+	sort.Slice(fetchTimes, func(i, j int) bool {
+		return fetchTimes[i].Before(fetchTimes[j])
+	})
+	for i := 1; i < len(fetchTimes); i++ {
+		if interval := fetchTimes[i].Sub(fetchTimes[i-1]); interval < 40*time.Millisecond {
+			t.Errorf("Crawl delay not respected. Interval between fetches was %s", interval)
+		}
+	}
+
+	// Robots override test
+	fetches = nil
+	options := DefaultCrawlOptions()
+	options.IgnoreRobots = true
+	options.UserAgent = "other"
+
+	page, err = CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if secret := page.Links[1].Page; secret.Skipped != "" {
+		t.Errorf("Page skipped with reason '%s' when ignoring the robots.txt", secret.Skipped)
+	}
+
+	for _, fetch := range fetches {
+		if fetch == "http://example.com/robots.txt" {
+			t.Error("The robots.txt was retrieved when ignoring it")
+		}
+	}
+
+	// Disallowed start page test
+	options.IgnoreRobots = false
+
+	page, err = CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if page.Skipped != SkipRobots || len(page.Links) > 0 {
+		t.Errorf("Disallowed start page crawled: %+v", page)
+	}
+}
+
+func TestCrawlMustUseTheRobotsOfEachHost(t *testing.T) {
+	var fetches []string
+	var fetchesLock sync.Mutex
+
+	data := map[string]string{
+		"http://example.com/robots.txt": `User-agent: *
+Disallow: /private`,
+		"http://www.example.com/robots.txt": `User-agent: *
+Disallow: /www-private`,
+		"http://example.com": `<html><body>
+  <a href="/private">Private</a>
+  <a href="http://www.example.com/www-private">WWW private</a>
+  <a href="http://www.example.com/private">WWW public</a>
+  <a href="http://example.net/private">External</a>
+</body></html>`,
+	}
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		fetchesLock.Lock()
+		fetches = append(fetches, url)
+		fetchesLock.Unlock()
+
+		if content, found := data[url]; found {
+			return htmlResponse(url, content), nil
+		}
+		return htmlResponse(url, `<html><body></body></html>`), nil
+	})
+
+	page, err := Crawl("http://example.com", fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	expectedSkipped := []SkipReason{SkipRobots, SkipRobots, "", ""}
+	for i, link := range page.Links {
+		if link.Page.Skipped != expectedSkipped[i] {
+			t.Errorf("Unexpected skip reason for %s. Expected '%s' and got '%s'",
+				link.Page.URL, expectedSkipped[i], link.Page.Skipped)
+		}
+	}
+
+	// Each robots.txt is retrieved only once, and only for the hosts of the site
+	sort.Strings(fetches)
+	expectedFetches := []string{
+		"http://example.com",
+		"http://example.com/robots.txt",
+		"http://www.example.com/private",
+		"http://www.example.com/robots.txt",
+	}
+	if !reflect.DeepEqual(fetches, expectedFetches) {
+		t.Errorf("Unexpected fetches. Expected '%v' and got '%v'", expectedFetches, fetches)
+	}
+}
+
+func TestCrawlMustHandleRobotsStatusCodes(t *testing.T) {
+	testData := []struct {
+		statusCode      int
+		ignoreRobots    bool
+		expectedSkipped SkipReason
+	}{
+		{statusCode: http.StatusNotFound},
+		{statusCode: http.StatusForbidden},
+		{statusCode: http.StatusServiceUnavailable, expectedSkipped: SkipRobots},
+		{statusCode: http.StatusInternalServerError, expectedSkipped: SkipRobots},
+		{statusCode: http.StatusServiceUnavailable, ignoreRobots: true},
+	}
+
+	for _, testItem := range testData {
+		fetcher := FakeFetcher(func(url string) (*Response, error) {
+			if url == "http://example.com/robots.txt" {
+				return &Response{URL: url, StatusCode: testItem.statusCode}, nil
+			}
+			return htmlResponse(url, `<html><body><a href="/about.html">About</a></body></html>`), nil
+		})
+
+		options := DefaultCrawlOptions()
+		options.IgnoreRobots = testItem.ignoreRobots
+
+		page, err := CrawlWithOptions("http://example.com", fetcher, options)
+		if err != nil {
+			t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+		}
+
+		if page.Skipped != testItem.expectedSkipped {
+			t.Errorf("Unexpected skip reason with robots.txt status %d (ignoring %t). "+
+				"Expected '%s' and got '%s'", testItem.statusCode, testItem.ignoreRobots,
+				testItem.expectedSkipped, page.Skipped)
+		}
+	}
+}
+
+func TestCrawlMustRespectNofollow(t *testing.T) {
+	data := map[string]string{
+		"http://example.com": `<html><body>
+  <a href="/sponsor.html" rel="external NOFOLLOW">Sponsor</a>
+  <a href="/about.html">About</a>
+</body></html>`,
+		"http://example.com/about.html": `<html>
+  <head><meta name="robots" content="noindex"></head>
+  <body><a href="/team.html">Team</a></body>
+</html>`,
+		"http://example.com/team.html":    `<html><body><a href="/member.html">Member</a></body></html>`,
+		"http://example.com/sponsor.html": `<html><body></body></html>`,
+		"http://example.com/member.html":  `<html><body></body></html>`,
+	}
+
+	testData := []struct {
+		ignoreNofollow  bool
+		expectedFetches []string
+	}{
+		{
+			expectedFetches: []string{
+				"http://example.com",
+				"http://example.com/about.html",
+				"http://example.com/team.html",
+			},
+		},
+		{
+			ignoreNofollow: true,
+			expectedFetches: []string{
+				"http://example.com",
+				"http://example.com/about.html",
+				"http://example.com/member.html",
+				"http://example.com/sponsor.html",
+				"http://example.com/team.html",
+			},
+		},
+	}
+
+	for _, testItem := range testData {
+		var fetches []string
+		var fetchesLock sync.Mutex
+
+		fetcher := FakeFetcher(func(url string) (*Response, error) {
+			fetchesLock.Lock()
+			fetches = append(fetches, url)
+			fetchesLock.Unlock()
+
+			response := htmlResponse(url, data[url])
+			if url == "http://example.com/team.html" {
+				response.Header = http.Header{"X-Robots-Tag": []string{"nofollow"}}
+			}
+			return response, nil
+		})
+
+		options := DefaultCrawlOptions()
+		options.IgnoreRobots = true
+		options.IgnoreNofollow = testItem.ignoreNofollow
+
+		page, err := CrawlWithOptions("http://example.com", fetcher, options)
+		if err != nil {
+			t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+		}
+
+		sort.Strings(fetches)
+		if !reflect.DeepEqual(fetches, testItem.expectedFetches) {
+			t.Errorf("Unexpected fetches ignoring nofollow %t. Expected '%v' and got '%v'",
+				testItem.ignoreNofollow, testItem.expectedFetches, fetches)
+		}
+
+		sponsor := page.Links[0]
+		if !sponsor.Nofollow || page.Links[1].Nofollow {
+			t.Errorf("Unexpected nofollow flags of the links: %t and %t",
+				sponsor.Nofollow, page.Links[1].Nofollow)
+		}
+
+		about := page.Links[1].Page
+		if !about.Noindex || about.Nofollow {
+			t.Errorf("Unexpected directives of the noindex page: %+v", about)
+		}
+
+		team := about.Links[0].Page
+		if team.Noindex || !team.Nofollow {
+			t.Errorf("Unexpected directives of the nofollow page: %+v", team)
+		}
+
+		if testItem.ignoreNofollow {
+			continue
+		}
+
+		if sponsor.Page.Skipped != SkipNofollow || team.Links[0].Page.Skipped != SkipNofollow {
+			t.Errorf("Unexpected skip reasons. Expected '%s' and got '%s' and '%s'", SkipNofollow,
+				sponsor.Page.Skipped, team.Links[0].Page.Skipped)
+		}
+	}
+}
+
+func TestCrawlMustFollowRefreshAndCanonical(t *testing.T) {
+	data := map[string]string{
+		"http://example.com": `<html><body>
+  <a href="/moved.html">Moved</a>
+  <a href="/article.html">Article</a>
+  <a href="/print.html">Print</a>
+</body></html>`,
+		"http://example.com/moved.html": `<html>
+  <head><meta http-equiv="Refresh" content="3; url=/new.html"></head>
+</html>`,
+		"http://example.com/article.html": `<html>
+  <head><link rel="canonical" href="/article.html"></head>
+</html>`,
+		"http://example.com/print.html": `<html>
+  <head><link rel="canonical" href="article.html"></head>
+  <body><a href="/extra.html">Extra</a></body>
+</html>`,
+		"http://example.com/new.html":   `<html><body></body></html>`,
+		"http://example.com/extra.html": `<html><body></body></html>`,
+	}
+
+	testData := []struct {
+		dedupeCanonical bool
+		expectedFetches []string
+	}{
+		{
+			expectedFetches: []string{
+				"http://example.com",
+				"http://example.com/article.html",
+				"http://example.com/extra.html",
+				"http://example.com/moved.html",
+				"http://example.com/new.html",
+				"http://example.com/print.html",
+			},
+		},
+		{
+			dedupeCanonical: true,
+			expectedFetches: []string{
+				"http://example.com",
+				"http://example.com/article.html",
+				"http://example.com/moved.html",
+				"http://example.com/new.html",
+				"http://example.com/print.html",
+			},
+		},
+	}
+
+	for _, testItem := range testData {
+		var fetches []string
+		var fetchesLock sync.Mutex
+
+		fetcher := FakeFetcher(func(url string) (*Response, error) {
+			fetchesLock.Lock()
+			fetches = append(fetches, url)
+			fetchesLock.Unlock()
+
+			return htmlResponse(url, data[url]), nil
+		})
+
+		options := DefaultCrawlOptions()
+		options.IgnoreRobots = true
+		options.DedupeCanonical = testItem.dedupeCanonical
+
+		page, err := CrawlWithOptions("http://example.com", fetcher, options)
+		if err != nil {
+			t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+		}
+
+		sort.Strings(fetches)
+		if !reflect.DeepEqual(fetches, testItem.expectedFetches) {
+			t.Errorf("Unexpected fetches deduping by canonical %t. Expected '%v' and got '%v'",
+				testItem.dedupeCanonical, testItem.expectedFetches, fetches)
+		}
+
+		moved := page.Links[0].Page
+		if len(moved.Links) != 1 {
+			t.Fatalf("Unexpected links of the refreshed page: %v", moved.Links)
+		}
+
+		refresh := moved.Links[0]
+		if !refresh.Refresh || refresh.RefreshDelay != 3*time.Second ||
+			refresh.Href != "/new.html" || refresh.Page.URL != "http://example.com/new.html" {
+			t.Errorf("Unexpected refresh link: %+v", refresh)
+		}
+
+		printPage := page.Links[2].Page
+		if printPage.Canonical != "http://example.com/article.html" {
+			t.Errorf("Unexpected canonical page: %+v", printPage)
+		}
+
+		// The canonical link is also an asset of the page, unless the page content was skipped
+		var expectedAssets []Asset
+		expectedSkipped := SkipReason("")
+		if testItem.dedupeCanonical {
+			expectedSkipped = SkipCanonical
+		} else {
+			expectedAssets = []Asset{{
+				URL:       "http://example.com/article.html",
+				Raw:       "article.html",
+				Kind:      AssetOther,
+				Tag:       "link",
+				Attribute: "href",
+				Rel:       "canonical",
+			}}
+		}
+		if !reflect.DeepEqual(printPage.StaticAssets, expectedAssets) {
+			t.Errorf("Unexpected assets deduping by canonical %t. Expected '%v' and got '%v'",
+				testItem.dedupeCanonical, expectedAssets, printPage.StaticAssets)
+		}
+
+		if printPage.Skipped != expectedSkipped {
+			t.Errorf("Unexpected skip reason deduping by canonical %t. Expected '%s' and got '%s'",
+				testItem.dedupeCanonical, expectedSkipped, printPage.Skipped)
+		}
+	}
+}
+
+func TestCrawlMustHandleRedirects(t *testing.T) {
+	data := map[string]string{
+		"http://example.com": `<html><body>
+  <a href="/old.html">Old</a>
+  <a href="/alias.html">Alias</a>
+  <a href="/moved.html">Moved</a>
+</body></html>`,
+		"http://example.com/new/index.html": `<html><body>
+  <a href="page.html">Page</a>
+</body></html>`,
+		"http://example.net/": `<html><body>
+  <a href="http://example.com/other.html">Other</a>
+</body></html>`,
+	}
+
+	redirects := map[string]string{
+		"http://example.com/old.html":   "http://example.com/new/index.html",
+		"http://example.com/alias.html": "http://example.com/new/index.html",
+		"http://example.com/moved.html": "http://example.net/",
+	}
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		location, found := redirects[url]
+		if !found {
+			return htmlResponse(url, data[url]), nil
+		}
+
+		response := htmlResponse(location, data[location])
+		response.Redirects = []Redirect{
+			{URL: url, StatusCode: http.StatusMovedPermanently, Location: location},
+		}
+		return response, nil
+	})
+
+	page, err := Crawl("http://example.com", fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	old, alias := page.Links[0].Page, page.Links[1].Page
+	if len(old.Redirects) != 1 || old.FinalURL != "http://example.com/new/index.html" {
+		t.Errorf("Unexpected redirected page: %+v", old)
+	}
+
+	// Only one of the addresses that redirect to the same page is analyzed, the other is the
+	// duplicated one
+	analyzed, duplicated := old, alias
+	if old.Skipped == SkipDuplicate {
+		analyzed, duplicated = alias, old
+	}
+
+	if duplicated.Skipped != SkipDuplicate || len(duplicated.Links) > 0 {
+		t.Errorf("Unexpected duplicated page: %+v", duplicated)
+	}
+
+	// Relative links must be resolved against the final URL
+	if analyzed.Skipped != "" || len(analyzed.Links) != 1 ||
+		analyzed.Links[0].Page.URL != "http://example.com/new/page.html" {
+		t.Errorf("Unexpected analyzed page: %+v", analyzed)
+	}
+
+	// The content of other sites isn't analyzed
+	if moved := page.Links[2].Page; moved.FinalURL != "http://example.net/" || len(moved.Links) > 0 {
+		t.Errorf("Unexpected page redirected to other site: %+v", moved)
+	}
+
+	scope, _ := NewScope("http://example.com")
+	redirectChains := RedirectChains(page, 1, scope)
+	if len(redirectChains) != 1 || redirectChains[0].URL != "http://example.com/moved.html" ||
+		!redirectChains[0].LeavesScope {
+		t.Errorf("Unexpected redirect chains: %v", redirectChains)
+	}
+}
+
+// trackingBody is the content of a response that records if it was read and closed
+type trackingBody struct {
+	reader io.Reader
+	read   bool
+	closed bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.reader.Read(p)
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCrawlMustLimitContent(t *testing.T) {
+	document := &trackingBody{reader: strings.NewReader("%PDF-1.4")}
+	big := &trackingBody{reader: strings.NewReader(`<html><body>` +
+		strings.Repeat("<p>Big page</p>", 100) + `<a href="/hidden.html">Hidden</a></body></html>`)}
+
+	data := `<html><body>
+  <a href="/manual.pdf">Manual</a>
+  <a href="/big.html">Big</a>
+</body></html>`
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		switch url {
+		case "http://example.com":
+			response := htmlResponse(url, data)
+			response.ContentLength = -1
+			return response, nil
+
+		case "http://example.com/manual.pdf":
+			return &Response{
+				URL:           url,
+				StatusCode:    http.StatusOK,
+				ContentType:   "application/pdf",
+				ContentLength: 2 * 1024 * 1024 * 1024,
+				Body:          document,
+			}, nil
+
+		case "http://example.com/big.html":
+			response := htmlResponse(url, "")
+			response.ContentLength = -1
+			response.Body = big
+			return response, nil
+		}
+
+		return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+	})
+
+	options := DefaultCrawlOptions()
+	options.MaxBodySize = 1024
+
+	page, err := CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	// The size of the content must be defined after reading it when it's unknown
+	if page.Document || page.ContentLength != int64(len(data)) {
+		t.Errorf("Unexpected HTML page: %+v", page)
+	}
+
+	manual := page.Links[0].Page
+	if !manual.Document || manual.Fail || manual.StatusCode != http.StatusOK ||
+		manual.ContentType != "application/pdf" {
+		t.Errorf("Unexpected document page: %+v", manual)
+	}
+
+	if document.read || !document.closed {
+		t.Errorf("Document content must be closed without being read. Read: %t, closed: %t",
+			document.read, document.closed)
+	}
+
+	bigPage := page.Links[1].Page
+	if !bigPage.Fail || bigPage.Error != ErrBodyTooLarge.Error() || len(bigPage.Links) > 0 {
+		t.Errorf("Unexpected big page: %+v", bigPage)
+	}
+
+	if !big.closed {
+		t.Error("Big page content not closed")
+	}
+}
+
+func TestCrawlMustCrawlStylesheets(t *testing.T) {
+	var fetches []string
+	var fetchesLock sync.Mutex
+
+	data := map[string]string{
+		"http://example.com": `<html>
+  <head>
+    <link rel="stylesheet" href="/css/main.css">
+    <link rel="stylesheet" href="http://cdn.example.net/lib.css">
+  </head>
+  <body><a href="/about.html">About</a></body>
+</html>`,
+		"http://example.com/about.html": `<html>
+  <head><link rel="stylesheet" href="/css/main.css"></head>
+</html>`,
+		"http://example.com/css/main.css": `@import "theme.css";
+@font-face { src: url(../fonts/text.woff2) format("woff2") }
+body { background: url('/img/bg.png') }
+.icon { background: url("data:image/png;base64,iVBORw0KGgo=") }
+.empty { background: url('') }`,
+		"http://example.com/css/theme.css": `@import url(main.css);
+h1 { background: url(missing.png) }`,
+	}
+
+	fetcher := FakeCheckerFetcher{
+		FakeFetcher: func(url string) (*Response, error) {
+			fetchesLock.Lock()
+			fetches = append(fetches, url)
+			fetchesLock.Unlock()
+
+			content, found := data[url]
+			if !found {
+				return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+			}
+
+			response := htmlResponse(url, content)
+			if strings.HasSuffix(url, ".css") {
+				response.ContentType = "text/css"
+			}
+			return response, nil
+		},
+		check: func(url string) (*Response, error) {
+			if url == "http://example.com/css/missing.png" {
+				return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+			}
+			return &Response{URL: url, StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	options := DefaultCrawlOptions()
+	options.IgnoreRobots = true
+	options.CheckResources = true
+	options.CrawlStylesheets = true
+
+	page, err := CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	// Each stylesheet of the site must be retrieved only once, even with cyclic imports, and
+	// the stylesheets of other sites are only checked
+	sort.Strings(fetches)
+	expectedFetches := []string{
+		"http://example.com",
+		"http://example.com/about.html",
+		"http://example.com/css/main.css",
+		"http://example.com/css/theme.css",
+	}
+	if !reflect.DeepEqual(fetches, expectedFetches) {
+		t.Errorf("Unexpected fetches. Expected '%v' and got '%v'", expectedFetches, fetches)
+	}
+
+	main := page.AssetChecks["http://example.com/css/main.css"]
+	if main == nil || !main.Stylesheet || main.Fail {
+		t.Fatalf("Unexpected main stylesheet: %+v", main)
+	}
+
+	if page.Links[0].Page.AssetChecks["http://example.com/css/main.css"] != main {
+		t.Error("Pages aren't sharing the same stylesheet object")
+	}
+
+	expectedAssets := []Asset{
+		{
+			URL:  "http://example.com/css/theme.css",
+			Raw:  "theme.css",
+			Kind: AssetStylesheet,
+		},
+		{
+			URL:  "http://example.com/fonts/text.woff2",
+			Raw:  "../fonts/text.woff2",
+			Kind: AssetFont,
+		},
+		{
+			URL:  "http://example.com/img/bg.png",
+			Raw:  "/img/bg.png",
+			Kind: AssetImage,
+		},
+	}
+	if !reflect.DeepEqual(main.StaticAssets, expectedAssets) {
+		t.Errorf("Unexpected stylesheet assets. Expected '%v' and got '%v'",
+			expectedAssets, main.StaticAssets)
+	}
+
+	theme := main.AssetChecks["http://example.com/css/theme.css"]
+	if theme == nil || !theme.Stylesheet ||
+		theme.AssetChecks["http://example.com/css/main.css"] != main {
+		t.Errorf("Unexpected imported stylesheet: %+v", theme)
+	}
+
+	if lib := page.AssetChecks["http://cdn.example.net/lib.css"]; lib == nil || lib.Stylesheet {
+		t.Errorf("Unexpected external stylesheet: %+v", lib)
+	}
+
+	brokenLinks := BrokenLinks(page)
+	if len(brokenLinks) != 1 ||
+		brokenLinks[0].URL != "http://example.com/css/missing.png" ||
+		len(brokenLinks[0].References) != 1 ||
+		brokenLinks[0].References[0].Page != "http://example.com/css/theme.css" {
+		t.Errorf("Unexpected broken links: %v", brokenLinks)
+	}
+}
+
+func TestCrawlMustUseSitemaps(t *testing.T) {
+	pages := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/</loc></url>
+  <url><loc>http://example.com/about.html</loc></url>
+  <url><loc>http://example.com/orphan.html</loc></url>
+  <url><loc>http://example.com/private.html</loc></url>
+  <url><loc>http://example.net/</loc></url>
+</urlset>`
+
+	data := map[string]string{
+		"http://example.com/robots.txt": `User-agent: *
+Disallow: /private.html
+
+Sitemap: http://example.com/sitemap_index.xml`,
+		"http://example.com/sitemap_index.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>/sitemaps/pages.xml.gz</loc></sitemap>
+  <sitemap><loc>http://example.com/sitemap_index.xml</loc></sitemap>
+</sitemapindex>`,
+		"http://example.com/sitemaps/pages.xml.gz": gzipContent(t, pages),
+		"http://example.com/sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/</loc></url>
+  <url><loc>http://example.com/legacy.html</loc></url>
+</urlset>`,
+		"http://example.com/legacy.html": `<html><body></body></html>`,
+		"http://example.com": `<html><body>
+  <a href="/about.html">About</a>
+  <a href="/contact.html">Contact</a>
+</body></html>`,
+		"http://example.com/about.html":   `<html><body></body></html>`,
+		"http://example.com/contact.html": `<html><body></body></html>`,
+		"http://example.com/orphan.html":  `<html><body><a href="/hidden.html">Hidden</a></body></html>`,
+		"http://example.com/hidden.html":  `<html><body></body></html>`,
+	}
+
+	var fetches []string
+	var fetchesLock sync.Mutex
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		fetchesLock.Lock()
+		fetches = append(fetches, url)
+		fetchesLock.Unlock()
+
+		content, found := data[url]
+		if !found {
+			return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+		}
+		return htmlResponse(url, content), nil
+	})
+
+	options := DefaultCrawlOptions()
+	options.UseSitemaps = true
+
+	page, err := CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	// Each sitemap is retrieved only once, including the /sitemap.xml that isn't declared in the
+	// robots.txt, and the pages of the sitemaps are only crawled when they weren't reached by
+	// links and the robots.txt allows it
+	sort.Strings(fetches)
+	expectedFetches := []string{
+		"http://example.com",
+		"http://example.com/about.html",
+		"http://example.com/contact.html",
+		"http://example.com/hidden.html",
+		"http://example.com/legacy.html",
+		"http://example.com/orphan.html",
+		"http://example.com/robots.txt",
+		"http://example.com/sitemap.xml",
+		"http://example.com/sitemap_index.xml",
+		"http://example.com/sitemaps/pages.xml.gz",
+	}
+	if !reflect.DeepEqual(fetches, expectedFetches) {
+		t.Errorf("Unexpected fetches. Expected '%v' and got '%v'", expectedFetches, fetches)
+	}
+
+	if len(page.Seeds) != 5 {
+		t.Fatalf("Unexpected number of seeds. Expected 5 and got %d", len(page.Seeds))
+	}
+
+	if page.Seeds[0].Page != page || !page.Seeds[0].CyclicPage ||
+		page.Seeds[2].Page != page.Links[0].Page || !page.Seeds[2].CyclicPage {
+		t.Errorf("Seeds reached by links aren't sharing the same page object")
+	}
+
+	if legacy := page.Seeds[1]; legacy.CyclicPage || legacy.Page.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected page of the default sitemap: %+v", legacy.Page)
+	}
+
+	orphan := page.Seeds[3]
+	if orphan.CyclicPage || orphan.Page.Depth != 0 || len(orphan.Page.Links) != 1 ||
+		orphan.Page.Links[0].Page.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected orphan page: %+v", orphan.Page)
+	}
+
+	if skipped := page.Seeds[4].Page.Skipped; skipped != SkipRobots {
+		t.Errorf("Unexpected skip reason. Expected '%s' and got '%s'", SkipRobots, skipped)
+	}
+
+	expectedOrphans := []string{
+		"http://example.com/legacy.html",
+		"http://example.com/orphan.html",
+		"http://example.com/private.html",
+	}
+	orphans := OrphanPages(page, options.Normalizer)
+	if !reflect.DeepEqual(orphans, expectedOrphans) {
+		t.Errorf("Unexpected orphan pages. Expected '%v' and got '%v'", expectedOrphans, orphans)
+	}
+
+	expectedMissing := []string{"http://example.com/contact.html"}
+	missing := MissingFromSitemap(page, options.Scope, options.Normalizer)
+	if !reflect.DeepEqual(missing, expectedMissing) {
+		t.Errorf("Unexpected pages missing from the sitemap. Expected '%v' and got '%v'",
+			expectedMissing, missing)
+	}
+
+	// Without sitemaps in the robots.txt the default address is used
+	delete(data, "http://example.com/robots.txt")
+	data["http://example.com/sitemap.xml"] = pages
+	fetches = nil
+
+	page, err = CrawlWithOptions("http://example.com", fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if len(page.Seeds) != 4 || page.Seeds[3].Page.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected seeds from the default sitemap: %v", page.Seeds)
+	}
+}
+
+func TestCrawlSeedsMustShareVisitedPages(t *testing.T) {
+	data := map[string]string{
+		"http://example.com/en/": `<html><body>
+  <a href="/pricing.html">Pricing</a>
+  <a href="/fr/">Français</a>
+</body></html>`,
+		"http://example.com/fr/":          `<html><body><a href="/pricing.html">Prix</a></body></html>`,
+		"http://example.com/landing/":     `<html><body><a href="/pricing.html">Buy</a></body></html>`,
+		"http://example.com/pricing.html": `<html><body></body></html>`,
+	}
+
+	var fetches []string
+	var fetchesLock sync.Mutex
+
+	fetcher := FakeFetcher(func(url string) (*Response, error) {
+		fetchesLock.Lock()
+		fetches = append(fetches, url)
+		fetchesLock.Unlock()
+
+		return htmlResponse(url, data[url]), nil
+	})
+
+	options := DefaultCrawlOptions()
+	options.IgnoreRobots = true
+
+	urls := []string{
+		"http://example.com/en/",
+		"http://example.com/landing/",
+		"http://example.com/fr/",
+		"http://example.com/en/",
+	}
+
+	pages, err := CrawlSeeds(context.Background(), urls, fetcher, options)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	// Pages reached from many start pages are retrieved only once
+	sort.Strings(fetches)
+	expectedFetches := []string{
+		"http://example.com/en/",
+		"http://example.com/fr/",
+		"http://example.com/landing/",
+		"http://example.com/pricing.html",
+	}
+	if !reflect.DeepEqual(fetches, expectedFetches) {
+		t.Errorf("Unexpected fetches. Expected '%v' and got '%v'", expectedFetches, fetches)
+	}
+
+	if len(pages) != len(urls) {
+		t.Fatalf("Unexpected number of pages. Expected %d and got %d", len(urls), len(pages))
+	}
+
+	for i, page := range pages {
+		if page.URL != urls[i] || page.Depth != 0 {
+			t.Errorf("Unexpected page for start URL %s: %+v", urls[i], page)
+		}
+	}
+
+	if pages[3] != pages[0] {
+		t.Error("Repeated start URL isn't sharing the same page object")
+	}
+
+	root := pages[0]
+	if len(root.Seeds) != 3 || root.Seeds[0].Page != pages[1] || root.Seeds[0].CyclicPage ||
+		root.Seeds[1].Page != pages[2] || !root.Seeds[2].CyclicPage {
+		t.Errorf("Unexpected seeds of the first start page: %v", root.Seeds)
+	}
+
+	// The French home page is a start page and is also linked by the English home page, so one
+	// of them is a cyclic reference
+	if root.Links[1].CyclicPage == root.Seeds[1].CyclicPage || root.Links[1].Page != pages[2] {
+		t.Errorf("Unexpected references to the French home page: %v and %v",
+			root.Links[1], root.Seeds[1])
+	}
+
+	var crawled []string
+	walkPages(root, func(p *Page) {
+		crawled = append(crawled, p.URL)
+	})
+	sort.Strings(crawled)
+	if !reflect.DeepEqual(crawled, expectedFetches) {
+		t.Errorf("Unexpected pages in the result. Expected '%v' and got '%v'",
+			expectedFetches, crawled)
+	}
+
+	if _, err := CrawlSeeds(context.Background(), nil, fetcher, options); err != ErrNoStartURL {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'", ErrNoStartURL, err)
+	}
+
+	// Start URLs of other sites are never fetched
+	fetches = nil
+	outOfScope := []string{"http://example.com", "http://example.net/landing.html"}
+	_, err = CrawlSeeds(context.Background(), outOfScope, fetcher, options)
+	if err != ErrStartURLOutOfScope {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'",
+			ErrStartURLOutOfScope, err)
+	}
+
+	if len(fetches) > 0 {
+		t.Errorf("Unexpected fetches with a start URL outside the scope: %v", fetches)
+	}
+}
+
 func TestCrawlStress(t *testing.T) {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	index := ""
@@ -591,8 +2168,8 @@ func TestCrawlStress(t *testing.T) {
 
 func BenchmarkCrawl(b *testing.B) {
 	for n := 0; n < b.N; n++ {
-		Crawl("example.com", FakeFetcher(func(url string) (io.Reader, error) {
-			return strings.NewReader("<html><body></body></html>"), nil
+		Crawl("http://example.com", FakeFetcher(func(url string) (*Response, error) {
+			return htmlResponse(url, "<html><body></body></html>"), nil
 		}))
 	}
 }
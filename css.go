@@ -0,0 +1,335 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// cssTokenType identifies the tokens of a CSS content that are relevant to find references
+type cssTokenType int
+
+// List of CSS tokens, based on the tokenization of the CSS Syntax Module. Tokens that don't
+// affect the references, like numbers and blocks, are returned as delimiters
+const (
+	cssEOF        cssTokenType = iota // End of the content
+	cssWhitespace                     // Sequence of spaces, tabs and line breaks
+	cssString                         // Quoted string, without the quotes and escapes
+	cssBadString                      // String interrupted by a line break
+	cssURL                            // Unquoted url() function, without the function name
+	cssBadURL                         // Unquoted url() function with invalid characters
+	cssFunction                       // Name of a function, like "url" in url("image.png")
+	cssAtKeyword                      // Name of an at-rule, like "import" in @import
+	cssIdent                          // Identifier, like property names and keywords
+	cssDelim                          // Any other character
+)
+
+// cssToken is a piece of a CSS content
+type cssToken struct {
+	kind  cssTokenType
+	value string
+}
+
+// is checks if the token has the type and the value, ignoring case
+func (t cssToken) is(kind cssTokenType, value string) bool {
+	return t.kind == kind && strings.EqualFold(t.value, value)
+}
+
+// cssReference is an address referenced by a CSS content
+type cssReference struct {
+	url      string // Address as it was written, without quotes and escapes
+	imported bool   // Flag to indicate that the address was referenced by an @import rule
+}
+
+// parseCSSURLs returns the references of a CSS content, found in url() functions and @import
+// rules. Comments and other strings are ignored
+func parseCSSURLs(css string) []cssReference {
+	var references []cssReference
+
+	tokenizer := cssTokenizer{input: css}
+	var previous, rule cssToken
+
+	for {
+		token := tokenizer.next()
+
+		switch token.kind {
+		case cssEOF:
+			return references
+
+		case cssWhitespace:
+			continue
+
+		case cssAtKeyword:
+			rule = token
+
+		case cssDelim:
+			if token.value == ";" || token.value == "{" || token.value == "}" {
+				rule = cssToken{}
+			}
+
+		case cssURL, cssString:
+			// Strings are only references when they are the argument of url() or the address
+			// of an @import rule
+			if token.kind == cssString && !previous.is(cssFunction, "url") &&
+				!previous.is(cssAtKeyword, "import") {
+				break
+			}
+
+			if value := strings.TrimSpace(token.value); len(value) > 0 {
+				references = append(references, cssReference{
+					url:      value,
+					imported: rule.is(cssAtKeyword, "import"),
+				})
+			}
+		}
+
+		previous = token
+	}
+}
+
+// cssTokenizer splits a CSS content into tokens
+type cssTokenizer struct {
+	input string // CSS content
+	pos   int    // Position of the next character to be analyzed
+}
+
+// next returns the next token of the content, skipping the comments
+func (t *cssTokenizer) next() cssToken {
+	for t.pos < len(t.input) {
+		c := t.input[t.pos]
+
+		switch {
+		case strings.HasPrefix(t.input[t.pos:], "/*"):
+			end := strings.Index(t.input[t.pos+2:], "*/")
+			if end < 0 {
+				t.pos = len(t.input)
+			} else {
+				t.pos += end + 4
+			}
+
+		case isCSSWhitespace(c):
+			for t.pos < len(t.input) && isCSSWhitespace(t.input[t.pos]) {
+				t.pos++
+			}
+			return cssToken{kind: cssWhitespace, value: " "}
+
+		case c == '"' || c == '\'':
+			return t.consumeString(c)
+
+		case c == '@':
+			t.pos++
+			if name := t.consumeName(); len(name) > 0 {
+				return cssToken{kind: cssAtKeyword, value: name}
+			}
+			return cssToken{kind: cssDelim, value: "@"}
+
+		case isCSSName(c) || c == '\\':
+			name := t.consumeName()
+			if len(name) == 0 {
+				// A backslash that doesn't start a valid escape
+				t.pos++
+				return cssToken{kind: cssDelim, value: string(c)}
+			}
+
+			if t.pos >= len(t.input) || t.input[t.pos] != '(' {
+				return cssToken{kind: cssIdent, value: name}
+			}
+			t.pos++
+
+			if strings.EqualFold(name, "url") {
+				// A quoted url() is returned as a function followed by a string, just like
+				// any other function
+				start := t.pos
+				t.skipWhitespace()
+				if t.pos < len(t.input) && (t.input[t.pos] == '"' || t.input[t.pos] == '\'') {
+					t.pos = start
+				} else {
+					return t.consumeURL()
+				}
+			}
+			return cssToken{kind: cssFunction, value: name}
+
+		default:
+			_, size := utf8.DecodeRuneInString(t.input[t.pos:])
+			t.pos += size
+			return cssToken{kind: cssDelim, value: t.input[t.pos-size : t.pos]}
+		}
+	}
+
+	return cssToken{kind: cssEOF}
+}
+
+// consumeString reads a string delimited by the quote, decoding the escapes. A line break
+// inside the string makes it invalid
+func (t *cssTokenizer) consumeString(quote byte) cssToken {
+	t.pos++
+
+	var value []byte
+	for t.pos < len(t.input) {
+		c := t.input[t.pos]
+
+		switch {
+		case c == quote:
+			t.pos++
+			return cssToken{kind: cssString, value: string(value)}
+
+		case c == '\n' || c == '\r' || c == '\f':
+			return cssToken{kind: cssBadString, value: string(value)}
+
+		case c == '\\':
+			if t.pos+1 < len(t.input) && isCSSNewline(t.input[t.pos+1]) {
+				// Escaped line break continues the string in the next line
+				t.pos += 2
+			} else if t.pos+1 < len(t.input) {
+				value = append(value, t.consumeEscape()...)
+			} else {
+				t.pos++
+			}
+
+		default:
+			value = append(value, c)
+			t.pos++
+		}
+	}
+
+	return cssToken{kind: cssString, value: string(value)}
+}
+
+// consumeURL reads the address of an unquoted url() function until the closing parenthesis.
+// Quotes, parentheses and whitespace inside the address make it invalid
+func (t *cssTokenizer) consumeURL() cssToken {
+	var value []byte
+	for t.pos < len(t.input) {
+		c := t.input[t.pos]
+
+		switch {
+		case c == ')':
+			t.pos++
+			return cssToken{kind: cssURL, value: string(value)}
+
+		case isCSSWhitespace(c):
+			t.skipWhitespace()
+			if t.pos >= len(t.input) || t.input[t.pos] == ')' {
+				continue
+			}
+			return t.consumeBadURL()
+
+		case c == '"' || c == '\'' || c == '(':
+			return t.consumeBadURL()
+
+		case c == '\\':
+			if t.pos+1 >= len(t.input) || isCSSNewline(t.input[t.pos+1]) {
+				return t.consumeBadURL()
+			}
+			value = append(value, t.consumeEscape()...)
+
+		default:
+			value = append(value, c)
+			t.pos++
+		}
+	}
+
+	return cssToken{kind: cssURL, value: string(value)}
+}
+
+// consumeBadURL skips the rest of an invalid url() function
+func (t *cssTokenizer) consumeBadURL() cssToken {
+	for t.pos < len(t.input) {
+		c := t.input[t.pos]
+		t.pos++
+
+		if c == ')' {
+			break
+		} else if c == '\\' && t.pos < len(t.input) {
+			t.pos++
+		}
+	}
+
+	return cssToken{kind: cssBadURL}
+}
+
+// consumeName reads an identifier, decoding the escapes
+func (t *cssTokenizer) consumeName() string {
+	var name []byte
+	for t.pos < len(t.input) {
+		c := t.input[t.pos]
+
+		if isCSSName(c) {
+			name = append(name, c)
+			t.pos++
+
+		} else if c == '\\' && t.pos+1 < len(t.input) && !isCSSNewline(t.input[t.pos+1]) {
+			name = append(name, t.consumeEscape()...)
+
+		} else {
+			break
+		}
+	}
+
+	return string(name)
+}
+
+// consumeEscape decodes the escape that starts in the current position (a backslash). The
+// escape is a character or up to 6 hexadecimal digits, optionally followed by a whitespace
+func (t *cssTokenizer) consumeEscape() []byte {
+	t.pos++
+
+	end := t.pos
+	for end < len(t.input) && end-t.pos < 6 && isHexDigit(t.input[end]) {
+		end++
+	}
+
+	if end == t.pos {
+		_, size := utf8.DecodeRuneInString(t.input[t.pos:])
+		t.pos += size
+		return []byte(t.input[t.pos-size : t.pos])
+	}
+
+	codePoint, _ := strconv.ParseUint(t.input[t.pos:end], 16, 32)
+	t.pos = end
+	if t.pos < len(t.input) && isCSSWhitespace(t.input[t.pos]) {
+		t.pos++
+	}
+
+	r := rune(codePoint)
+	if codePoint == 0 || !utf8.ValidRune(r) {
+		r = utf8.RuneError
+	}
+
+	buffer := make([]byte, utf8.RuneLen(r))
+	utf8.EncodeRune(buffer, r)
+	return buffer
+}
+
+// skipWhitespace advances the position until the next character that isn't a whitespace
+func (t *cssTokenizer) skipWhitespace() {
+	for t.pos < len(t.input) && isCSSWhitespace(t.input[t.pos]) {
+		t.pos++
+	}
+}
+
+// isCSSWhitespace checks if the character is a whitespace for the CSS syntax
+func isCSSWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || isCSSNewline(c)
+}
+
+// isCSSNewline checks if the character is a line break for the CSS syntax
+func isCSSNewline(c byte) bool {
+	return c == '\n' || c == '\r' || c == '\f'
+}
+
+// isCSSName checks if the character can be part of an identifier. Non-ASCII characters are
+// always allowed
+func isCSSName(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c >= 0x80
+}
+
+// isHexDigit checks if the character is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
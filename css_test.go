@@ -0,0 +1,71 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCSSURLs(t *testing.T) {
+	testData := []struct {
+		css      string
+		expected []cssReference
+	}{
+		{css: "", expected: nil},
+		{css: "background: url(image.png)", expected: []cssReference{{url: "image.png"}}},
+		{css: `background: URL( "image.png" )`, expected: []cssReference{{url: "image.png"}}},
+		{css: "background: url('image.png')", expected: []cssReference{{url: "image.png"}}},
+		{css: "background: url(  image.png  )", expected: []cssReference{{url: "image.png"}}},
+		{css: "background: url()", expected: nil},
+		{
+			css: `@import "a.css"; @import url(b.css) screen; @IMPORT 'c.css'`,
+			expected: []cssReference{
+				{url: "a.css", imported: true},
+				{url: "b.css", imported: true},
+				{url: "c.css", imported: true},
+			},
+		},
+		{
+			css:      `@import "a.css"; body { background: url(b.png) }`,
+			expected: []cssReference{{url: "a.css", imported: true}, {url: "b.png"}},
+		},
+		{
+			css:      "/* url(ignored.png) */ a { b: url(c.png) } /* unclosed url(d.png)",
+			expected: []cssReference{{url: "c.png"}},
+		},
+		{
+			css:      `a { content: "url(ignored.png)"; background: url(b.png) }`,
+			expected: []cssReference{{url: "b.png"}},
+		},
+		{
+			css:      `a { background: url(with\ space.png), url("quote\"d.png") }`,
+			expected: []cssReference{{url: "with space.png"}, {url: `quote"d.png`}},
+		},
+		{
+			css:      `a { background: url(\69 mage.png) }`,
+			expected: []cssReference{{url: "image.png"}},
+		},
+		{
+			css:      `a { background: url(bad url.png); color: red } b { c: url(ok.png) }`,
+			expected: []cssReference{{url: "ok.png"}},
+		},
+		{
+			css:      "a { background: url(\"broken\n.png) } b { c: url(ok.png) }",
+			expected: []cssReference{{url: "ok.png"}},
+		},
+		{
+			css:      `@font-face { src: url(font.woff2) format("woff2"), url(font.woff) format("woff") }`,
+			expected: []cssReference{{url: "font.woff2"}, {url: "font.woff"}},
+		},
+	}
+
+	for _, testItem := range testData {
+		if references := parseCSSURLs(testItem.css); !reflect.DeepEqual(references, testItem.expected) {
+			t.Errorf("Unexpected references for CSS '%s'. Expected '%v' and got '%v'",
+				testItem.css, testItem.expected, references)
+		}
+	}
+}
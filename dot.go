@@ -0,0 +1,151 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Colors used to identify the state of the pages in the DOT graph
+const (
+	dotColorInScope  = "forestgreen"
+	dotColorExternal = "gray50"
+	dotColorFail     = "red"
+	dotColorSkipped  = "orange"
+)
+
+// WriteDOT writes the link graph of the page tree in the Graphviz DOT language. Each distinct
+// page URL is a node, colored by its state (in scope, external, failed or skipped), and each
+// link is an edge labeled with the link label. The URLs are compared using the normalizer, that
+// can be nil. A page URL that was crawled is never drawn as skipped, even when it was also
+// found in links that weren't followed. When clusterDepth is greater than zero the pages of
+// the site are grouped in clusters by the first clusterDepth segments of the path
+func WriteDOT(w io.Writer, page *Page, normalizer Normalizer, clusterDepth int) error {
+	pages := distinctPages(page, normalizer)
+	ids := make(map[string]string)
+
+	for i, p := range pages {
+		ids[normalizeURL(normalizer, p.URL)] = fmt.Sprintf("p%d", i)
+	}
+
+	clusters := make(map[string][]*Page)
+	var unclustered []*Page
+
+	for _, p := range pages {
+		if clusterDepth > 0 && !p.External {
+			if prefix, ok := pathPrefix(p.URL, clusterDepth); ok {
+				clusters[prefix] = append(clusters[prefix], p)
+				continue
+			}
+		}
+
+		unclustered = append(unclustered, p)
+	}
+
+	var prefixes []string
+	for prefix := range clusters {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	buffer := bufio.NewWriter(w)
+	fmt.Fprintln(buffer, "digraph site {")
+	fmt.Fprintln(buffer, "  node [shape=box, style=rounded];")
+
+	for i, prefix := range prefixes {
+		fmt.Fprintf(buffer, "\n  subgraph cluster%d {\n", i)
+		fmt.Fprintf(buffer, "    label=%s;\n", dotQuote(prefix))
+		for _, p := range clusters[prefix] {
+			fmt.Fprintf(buffer, "    %s;\n", dotNode(ids[normalizeURL(normalizer, p.URL)], p))
+		}
+		fmt.Fprintln(buffer, "  }")
+	}
+
+	if len(unclustered) > 0 {
+		fmt.Fprintln(buffer)
+	}
+	for _, p := range unclustered {
+		fmt.Fprintf(buffer, "  %s;\n", dotNode(ids[normalizeURL(normalizer, p.URL)], p))
+	}
+
+	edges := false
+	for _, p := range pages {
+		for _, link := range p.Links {
+			if link.Page == nil {
+				continue
+			}
+
+			if !edges {
+				fmt.Fprintln(buffer)
+				edges = true
+			}
+
+			fmt.Fprintf(buffer, "  %s -> %s [label=%s];\n",
+				ids[normalizeURL(normalizer, p.URL)], ids[normalizeURL(normalizer, link.Page.URL)],
+				dotQuote(link.Label))
+		}
+	}
+
+	fmt.Fprintln(buffer, "}")
+	return buffer.Flush()
+}
+
+// dotNode returns the DOT statement of a page node, with the attributes that represent the
+// state of the page
+func dotNode(id string, page *Page) string {
+	color := dotColorInScope
+	style := "rounded"
+
+	switch {
+	case page.Fail:
+		color = dotColorFail
+	case len(page.Skipped) > 0:
+		color = dotColorSkipped
+		style = "rounded,dashed"
+	case page.External:
+		color = dotColorExternal
+	}
+
+	return fmt.Sprintf("%s [label=%s, color=%s, style=%s]",
+		id, dotQuote(page.URL), color, dotQuote(style))
+}
+
+// dotQuote converts the text into a DOT quoted string
+func dotQuote(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(text) + `"`
+}
+
+// pathPrefix returns the first segments of the URL path, used to cluster the pages. Pages
+// with less segments than the depth are grouped by all the segments they have
+func pathPrefix(rawURL string, depth int) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+
+	// The last segment is the page itself, and not a directory
+	if len(segments) > 0 && !strings.HasSuffix(u.Path, "/") {
+		segments = segments[:len(segments)-1]
+	}
+
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+
+	return "/" + strings.Join(segments, "/"), true
+}
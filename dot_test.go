@@ -0,0 +1,162 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	page := &Page{URL: "http://example.com/"}
+	guide := &Page{URL: "http://example.com/docs/guide.html"}
+
+	page.Links = []Link{
+		{Label: "Guide", Page: guide},
+		{Label: "Missing \"page\"", Page: &Page{URL: "http://example.com/docs/api/missing.html",
+			Fail: true}},
+		{Label: "External", Page: &Page{URL: "http://example.net", External: true}},
+		{Label: "Anchor"},
+	}
+
+	guide.Links = []Link{
+		{Label: "Home", Page: page, CyclicPage: true},
+		{Label: "Deep", Page: &Page{URL: "http://example.com/blog/", Skipped: SkipMaxDepth}},
+	}
+
+	testData := []struct {
+		clusterDepth int
+		expected     string
+	}{
+		{
+			clusterDepth: 0,
+			expected: `digraph site {
+  node [shape=box, style=rounded];
+
+  p0 [label="http://example.com/", color=forestgreen, style="rounded"];
+  p1 [label="http://example.com/docs/guide.html", color=forestgreen, style="rounded"];
+  p2 [label="http://example.com/blog/", color=orange, style="rounded,dashed"];
+  p3 [label="http://example.com/docs/api/missing.html", color=red, style="rounded"];
+  p4 [label="http://example.net", color=gray50, style="rounded"];
+
+  p0 -> p1 [label="Guide"];
+  p0 -> p3 [label="Missing \"page\""];
+  p0 -> p4 [label="External"];
+  p1 -> p0 [label="Home"];
+  p1 -> p2 [label="Deep"];
+}
+`,
+		},
+		{
+			clusterDepth: 1,
+			expected: `digraph site {
+  node [shape=box, style=rounded];
+
+  subgraph cluster0 {
+    label="/";
+    p0 [label="http://example.com/", color=forestgreen, style="rounded"];
+  }
+
+  subgraph cluster1 {
+    label="/blog";
+    p2 [label="http://example.com/blog/", color=orange, style="rounded,dashed"];
+  }
+
+  subgraph cluster2 {
+    label="/docs";
+    p1 [label="http://example.com/docs/guide.html", color=forestgreen, style="rounded"];
+    p3 [label="http://example.com/docs/api/missing.html", color=red, style="rounded"];
+  }
+
+  p4 [label="http://example.net", color=gray50, style="rounded"];
+
+  p0 -> p1 [label="Guide"];
+  p0 -> p3 [label="Missing \"page\""];
+  p0 -> p4 [label="External"];
+  p1 -> p0 [label="Home"];
+  p1 -> p2 [label="Deep"];
+}
+`,
+		},
+	}
+
+	for _, testItem := range testData {
+		var output bytes.Buffer
+		if err := WriteDOT(&output, page, nil, testItem.clusterDepth); err != nil {
+			t.Fatal(err)
+		}
+
+		if output.String() != testItem.expected {
+			t.Errorf("Unexpected DOT output with cluster depth %d. Expected %s and got %s",
+				testItem.clusterDepth, testItem.expected, output.String())
+		}
+	}
+}
+
+func TestWriteDOTMustPreferCrawledPages(t *testing.T) {
+	b := &Page{
+		URL:   "http://example.com/b",
+		Links: []Link{{Label: "D", Page: &Page{URL: "http://example.com/d"}}},
+	}
+
+	page := &Page{
+		URL: "http://example.com/",
+		Links: []Link{
+			{Label: "B", Nofollow: true, Page: &Page{URL: "http://example.com/b#top",
+				Skipped: SkipNofollow}},
+			{Label: "C", Page: &Page{
+				URL:   "http://example.com/c",
+				Links: []Link{{Label: "B", Page: b}},
+			}},
+		},
+	}
+
+	expected := `digraph site {
+  node [shape=box, style=rounded];
+
+  p0 [label="http://example.com/", color=forestgreen, style="rounded"];
+  p1 [label="http://example.com/b", color=forestgreen, style="rounded"];
+  p2 [label="http://example.com/c", color=forestgreen, style="rounded"];
+  p3 [label="http://example.com/d", color=forestgreen, style="rounded"];
+
+  p0 -> p1 [label="B"];
+  p0 -> p2 [label="C"];
+  p1 -> p3 [label="D"];
+  p2 -> p1 [label="B"];
+}
+`
+
+	var output bytes.Buffer
+	if err := WriteDOT(&output, page, NewURLNormalizer(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if output.String() != expected {
+		t.Errorf("Unexpected DOT output. Expected %s and got %s", expected, output.String())
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	testData := []struct {
+		url      string
+		depth    int
+		expected string
+	}{
+		{url: "http://example.com", depth: 1, expected: "/"},
+		{url: "http://example.com/index.html", depth: 1, expected: "/"},
+		{url: "http://example.com/docs/", depth: 1, expected: "/docs"},
+		{url: "http://example.com/docs/api/v1/index.html", depth: 1, expected: "/docs"},
+		{url: "http://example.com/docs/api/v1/index.html", depth: 2, expected: "/docs/api"},
+		{url: "http://example.com/docs/index.html", depth: 2, expected: "/docs"},
+	}
+
+	for _, testItem := range testData {
+		prefix, ok := pathPrefix(testItem.url, testItem.depth)
+		if !ok || prefix != testItem.expected {
+			t.Errorf("Unexpected path prefix for '%s' with depth %d. Expected '%s' and got '%s'",
+				testItem.url, testItem.depth, testItem.expected, prefix)
+		}
+	}
+}
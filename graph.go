@@ -0,0 +1,201 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"sort"
+)
+
+// SiteGraph is the link graph of a crawl result. Instead of a tree where the revisited pages
+// are cyclic links, each distinct page URL is a node that knows the links found in the page
+// and the links that reference it, so backlinks can be answered without walking the tree
+type SiteGraph struct {
+	Roots []string              // Addresses of the start pages of the crawl
+	Nodes map[string]*GraphNode // Pages of the crawl indexed by the normalized page URL
+
+	// aliases indexes the node keys by the normalized final URL of the pages, so the queries
+	// also accept the address where the content was really found
+	aliases    map[string]string
+	normalizer Normalizer
+}
+
+// GraphNode is a page of the site graph with its outgoing and incoming links
+type GraphNode struct {
+	URL      string       // Address of the page, as it was found in the crawl
+	Page     *Page        // Page information, crawled pages are preferred over skipped ones
+	Outlinks []*GraphEdge // Links found in the page, in the order they were first found
+	Inlinks  []*GraphEdge // Links of other pages that reference the page, sorted by source URL
+}
+
+// GraphEdge groups all links from a page to another page
+type GraphEdge struct {
+	From   string   // Address of the page that contains the links
+	To     string   // Address of the referenced page
+	Labels []string // Distinct labels of the links, in the order they were found
+	Count  int      // Number of links from the source page to the target page
+}
+
+// NewSiteGraph converts the page tree into the link graph, including the other start pages
+// and the pages found in the sitemaps (see Page.Seeds). Pages are identified by the URL
+// converted with the normalizer, that can be nil, so different representations of the same
+// address (like "/b" and "/b#top") are the same node. The queries are compared in the same way
+func NewSiteGraph(page *Page, normalizer Normalizer) *SiteGraph {
+	graph := &SiteGraph{
+		Nodes:      make(map[string]*GraphNode),
+		aliases:    make(map[string]string),
+		normalizer: normalizer,
+	}
+
+	graph.Roots = append(graph.Roots, page.URL)
+	for _, seed := range page.Seeds {
+		if !seed.Sitemap {
+			graph.Roots = append(graph.Roots, seed.Href)
+		}
+	}
+
+	var keys []string
+	for _, p := range distinctPages(page, normalizer) {
+		key := normalizeURL(normalizer, p.URL)
+		graph.Nodes[key] = &GraphNode{URL: p.URL, Page: p}
+		keys = append(keys, key)
+	}
+
+	// Only the links of the page that represents the node are used
+	for _, key := range keys {
+		node := graph.Nodes[key]
+		if len(node.Page.FinalURL) > 0 {
+			graph.addAlias(node.Page.FinalURL, key)
+		}
+
+		edges := make(map[string]*GraphEdge)
+		for _, link := range node.Page.Links {
+			if link.Page == nil {
+				continue
+			}
+
+			targetKey := normalizeURL(graph.normalizer, link.Page.URL)
+			target := graph.Nodes[targetKey]
+
+			edge, found := edges[targetKey]
+			if !found {
+				edge = &GraphEdge{
+					From: node.URL,
+					To:   target.URL,
+				}
+				edges[targetKey] = edge
+
+				node.Outlinks = append(node.Outlinks, edge)
+				target.Inlinks = append(target.Inlinks, edge)
+			}
+
+			edge.Count++
+			if !containsString(edge.Labels, link.Label) {
+				edge.Labels = append(edge.Labels, link.Label)
+			}
+		}
+	}
+
+	for _, node := range graph.Nodes {
+		inlinks := node.Inlinks
+		sort.Slice(inlinks, func(i, j int) bool {
+			return inlinks[i].From < inlinks[j].From
+		})
+	}
+
+	return graph
+}
+
+// addAlias registers another address of the node, unless it's already used by another node
+func (g *SiteGraph) addAlias(address, key string) {
+	alias := normalizeURL(g.normalizer, address)
+	if _, found := g.Nodes[alias]; found {
+		return
+	}
+
+	if _, found := g.aliases[alias]; !found {
+		g.aliases[alias] = key
+	}
+}
+
+// Node returns the node of the page with the URL, or nil when the page isn't in the graph. The
+// URL is compared with the address and the final address of the pages
+func (g *SiteGraph) Node(url string) *GraphNode {
+	key := normalizeURL(g.normalizer, url)
+	if node, found := g.Nodes[key]; found {
+		return node
+	}
+
+	if key, found := g.aliases[key]; found {
+		return g.Nodes[key]
+	}
+
+	return nil
+}
+
+// Inlinks returns the links of other pages that reference the page with the URL, sorted by
+// the source URL
+func (g *SiteGraph) Inlinks(url string) []*GraphEdge {
+	if node := g.Node(url); node != nil {
+		return node.Inlinks
+	}
+	return nil
+}
+
+// Outlinks returns the links found in the page with the URL, in the order they were first found
+func (g *SiteGraph) Outlinks(url string) []*GraphEdge {
+	if node := g.Node(url); node != nil {
+		return node.Outlinks
+	}
+	return nil
+}
+
+// ShortestPath returns the addresses of the pages in the shortest sequence of links from a
+// page to another, including both pages. When there's more than one shortest path, the links
+// found first in each page are preferred. If the target page can't be reached nil is returned
+func (g *SiteGraph) ShortestPath(from, to string) []string {
+	source, target := g.Node(from), g.Node(to)
+	if source == nil || target == nil {
+		return nil
+	}
+
+	// Breadth-first search storing the page where each page was found from
+	previous := map[*GraphNode]*GraphNode{source: nil}
+	queue := []*GraphNode{source}
+
+	for len(queue) > 0 && target != queue[0] {
+		node := queue[0]
+		queue = queue[1:]
+
+		for _, edge := range node.Outlinks {
+			next := g.Node(edge.To)
+			if _, visited := previous[next]; visited {
+				continue
+			}
+
+			previous[next] = node
+			queue = append(queue, next)
+		}
+	}
+
+	if _, reached := previous[target]; !reached {
+		return nil
+	}
+
+	var path []string
+	for node := target; node != nil; node = previous[node] {
+		path = append([]string{node.URL}, path...)
+	}
+	return path
+}
+
+// containsString checks if the value is in the list
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSiteGraph(t *testing.T) {
+	home := &Page{URL: "http://example.com", FinalURL: "http://example.com/"}
+	about := &Page{URL: "http://example.com/about.html"}
+	pricing := &Page{URL: "http://example.com/pricing.html"}
+	team := &Page{URL: "http://example.com/team.html"}
+	external := &Page{URL: "http://example.net", External: true}
+
+	home.Links = []Link{
+		{Label: "Pricing", Page: pricing},
+		{Label: "About", Page: about},
+		{Label: "Buy now", Page: pricing, CyclicPage: true},
+		{Label: "Pricing", Page: pricing, CyclicPage: true},
+		{Label: "Team", Page: &Page{URL: "http://example.com/team.html", Skipped: SkipNofollow}},
+		{Label: "Anchor"},
+	}
+
+	about.Links = []Link{
+		{Label: "Plans", Page: pricing, CyclicPage: true},
+		{Label: "Home", Page: home, CyclicPage: true},
+		{Label: "Team", Page: team},
+	}
+
+	pricing.Links = []Link{
+		{Label: "Partner", Page: external},
+	}
+
+	landing := &Page{
+		URL: "http://example.com/landing.html",
+		Links: []Link{
+			{Label: "About us", Page: about, CyclicPage: true},
+		},
+	}
+
+	home.Seeds = []Link{
+		{Label: "<start page>", Href: "http://example.com/landing.html", Page: landing},
+		{Label: "<sitemap>", Href: "http://example.com/about.html", Sitemap: true, Page: about,
+			CyclicPage: true},
+	}
+
+	graph := NewSiteGraph(home, NewURLNormalizer())
+
+	expectedRoots := []string{"http://example.com", "http://example.com/landing.html"}
+	if !reflect.DeepEqual(graph.Roots, expectedRoots) {
+		t.Errorf("Unexpected roots. Expected '%v' and got '%v'", expectedRoots, graph.Roots)
+	}
+
+	if len(graph.Nodes) != 6 {
+		t.Errorf("Unexpected number of nodes. Expected 6 and got %d", len(graph.Nodes))
+	}
+
+	if node := graph.Node("http://example.com/team.html"); node == nil || node.Page != team {
+		t.Errorf("Crawled page isn't preferred over the skipped one: %+v", node)
+	}
+
+	expectedInlinks := []*GraphEdge{
+		{
+			From:   "http://example.com",
+			To:     "http://example.com/pricing.html",
+			Labels: []string{"Pricing", "Buy now"},
+			Count:  3,
+		},
+		{
+			From:   "http://example.com/about.html",
+			To:     "http://example.com/pricing.html",
+			Labels: []string{"Plans"},
+			Count:  1,
+		},
+	}
+
+	// Any representation of the address can be used in the queries
+	for _, url := range []string{
+		"http://example.com/pricing.html",
+		"HTTP://EXAMPLE.COM/pricing.html",
+	} {
+		if inlinks := graph.Inlinks(url); !reflect.DeepEqual(inlinks, expectedInlinks) {
+			t.Errorf("Unexpected inlinks of %s. Expected '%v' and got '%v'",
+				url, expectedInlinks, inlinks)
+		}
+	}
+
+	// The final URL of a page also identifies it
+	inlinks := graph.Inlinks("http://example.com/")
+	if len(inlinks) != 1 || inlinks[0].From != "http://example.com/about.html" {
+		t.Errorf("Unexpected inlinks of the start page: %v", inlinks)
+	}
+
+	var outlinks []string
+	for _, edge := range graph.Outlinks("http://example.com") {
+		outlinks = append(outlinks, edge.To)
+	}
+
+	expectedOutlinks := []string{
+		"http://example.com/pricing.html",
+		"http://example.com/about.html",
+		"http://example.com/team.html",
+	}
+	if !reflect.DeepEqual(outlinks, expectedOutlinks) {
+		t.Errorf("Unexpected outlinks. Expected '%v' and got '%v'", expectedOutlinks, outlinks)
+	}
+
+	if graph.Inlinks("http://example.com/unknown.html") != nil ||
+		graph.Outlinks("http://example.com/unknown.html") != nil {
+		t.Error("Unexpected links of an unknown page")
+	}
+}
+
+func TestSiteGraphMustNormalizeURLs(t *testing.T) {
+	b := &Page{URL: "http://example.com/b"}
+	page := &Page{
+		URL: "http://example.com/",
+		Links: []Link{
+			{Label: "Top", Nofollow: true, Page: &Page{URL: "http://example.com/b#top",
+				Skipped: SkipNofollow}},
+			{Label: "B", Page: b},
+		},
+	}
+
+	graph := NewSiteGraph(page, NewURLNormalizer())
+
+	if len(graph.Nodes) != 2 {
+		t.Errorf("Unexpected number of nodes. Expected 2 and got %d", len(graph.Nodes))
+	}
+
+	node := graph.Node("http://example.com/b#top")
+	if node == nil || node.Page != b || node.URL != "http://example.com/b" {
+		t.Errorf("Unexpected node of the page with fragment: %+v", node)
+	}
+
+	expectedInlinks := []*GraphEdge{
+		{
+			From:   "http://example.com/",
+			To:     "http://example.com/b",
+			Labels: []string{"Top", "B"},
+			Count:  2,
+		},
+	}
+	if inlinks := graph.Inlinks("http://example.com/b"); !reflect.DeepEqual(inlinks, expectedInlinks) {
+		t.Errorf("Unexpected inlinks. Expected '%v' and got '%v'", expectedInlinks, inlinks)
+	}
+}
+
+func TestSiteGraphShortestPath(t *testing.T) {
+	home := &Page{URL: "http://example.com"}
+	about := &Page{URL: "http://example.com/about.html"}
+	pricing := &Page{URL: "http://example.com/pricing.html"}
+	checkout := &Page{URL: "http://example.com/checkout.html"}
+
+	home.Links = []Link{
+		{Label: "About", Page: about},
+		{Label: "Pricing", Page: pricing},
+	}
+	about.Links = []Link{
+		{Label: "Home", Page: home, CyclicPage: true},
+		{Label: "Checkout", Page: checkout},
+	}
+	pricing.Links = []Link{
+		{Label: "Checkout", Page: checkout, CyclicPage: true},
+	}
+
+	graph := NewSiteGraph(home, nil)
+
+	testData := []struct {
+		from     string
+		to       string
+		expected []string
+	}{
+		{
+			from: "http://example.com",
+			to:   "http://example.com/checkout.html",
+			expected: []string{
+				"http://example.com",
+				"http://example.com/about.html",
+				"http://example.com/checkout.html",
+			},
+		},
+		{
+			from: "http://example.com/about.html",
+			to:   "http://example.com/pricing.html",
+			expected: []string{
+				"http://example.com/about.html",
+				"http://example.com",
+				"http://example.com/pricing.html",
+			},
+		},
+		{
+			from:     "http://example.com/pricing.html",
+			to:       "http://example.com/pricing.html",
+			expected: []string{"http://example.com/pricing.html"},
+		},
+		{
+			from:     "http://example.com/checkout.html",
+			to:       "http://example.com",
+			expected: nil,
+		},
+		{
+			from:     "http://example.com",
+			to:       "http://example.com/unknown.html",
+			expected: nil,
+		},
+	}
+
+	for _, testItem := range testData {
+		path := graph.ShortestPath(testItem.from, testItem.to)
+		if !reflect.DeepEqual(path, testItem.expected) {
+			t.Errorf("Unexpected path from %s to %s. Expected '%v' and got '%v'",
+				testItem.from, testItem.to, testItem.expected, path)
+		}
+	}
+}
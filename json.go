@@ -0,0 +1,216 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JSONSiteMap is the JSON representation of a crawl result. Instead of a tree, the pages are
+// stored in a flat list and the links reference the target page by URL, so cycles don't
+// need any special treatment
+type JSONSiteMap struct {
+	Root        string           `json:"root"`                  // Address of the start page
+	Pages       []JSONPage       `json:"pages"`                 // Distinct pages of the crawl
+	Stylesheets []JSONStylesheet `json:"stylesheets,omitempty"` // Analyzed stylesheets
+	Roots       []string         `json:"roots,omitempty"`       // Start pages, when there are many
+	Seeds       []string         `json:"seeds,omitempty"`       // Pages found in the sitemaps
+}
+
+// JSONPage is the JSON representation of a Page. The duration (durationMs) is the time to first
+// byte in milliseconds, as the body is streamed (see Page.Duration)
+type JSONPage struct {
+	URL           string         `json:"url"`
+	FinalURL      string         `json:"finalUrl,omitempty"`
+	Canonical     string         `json:"canonical,omitempty"`
+	Depth         int            `json:"depth"`
+	External      bool           `json:"external,omitempty"`
+	Fail          bool           `json:"fail,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Skipped       SkipReason     `json:"skipped,omitempty"`
+	Document      bool           `json:"document,omitempty"`
+	Noindex       bool           `json:"noindex,omitempty"`
+	Nofollow      bool           `json:"nofollow,omitempty"`
+	StatusCode    int            `json:"statusCode,omitempty"`
+	ContentType   string         `json:"contentType,omitempty"`
+	ContentLength int64          `json:"contentLength,omitempty"`
+	Duration      int64          `json:"durationMs,omitempty"`
+	Attempts      int            `json:"attempts,omitempty"`
+	Redirects     []JSONRedirect `json:"redirects,omitempty"`
+	Links         []JSONLink     `json:"links,omitempty"`
+	StaticAssets  []JSONAsset    `json:"staticAssets,omitempty"`
+}
+
+// JSONLink is the JSON representation of a Link. The target page is identified by the URL
+// field, that is empty for links without href
+type JSONLink struct {
+	Label        string `json:"label"`
+	Href         string `json:"href,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Nofollow     bool   `json:"nofollow,omitempty"`
+	Cyclic       bool   `json:"cyclic,omitempty"`
+	Refresh      bool   `json:"refresh,omitempty"`
+	RefreshDelay int64  `json:"refreshDelayMs,omitempty"`
+}
+
+// JSONRedirect is the JSON representation of a Redirect
+type JSONRedirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	Location   string `json:"location"`
+}
+
+// JSONAsset is the JSON representation of a static asset. The availability fields are only
+// filled when the resources were checked
+type JSONAsset struct {
+	URL         string    `json:"url"`
+	Raw         string    `json:"raw,omitempty"`
+	Kind        AssetKind `json:"kind,omitempty"`
+	Tag         string    `json:"tag,omitempty"`
+	Attribute   string    `json:"attribute,omitempty"`
+	Rel         string    `json:"rel,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Integrity   string    `json:"integrity,omitempty"`
+	CrossOrigin string    `json:"crossOrigin,omitempty"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Fail        bool      `json:"fail,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// JSONStylesheet is the JSON representation of a stylesheet analyzed during the crawl (see
+// CrawlOptions.CrawlStylesheets) with its dependencies
+type JSONStylesheet struct {
+	URL          string      `json:"url"`
+	StatusCode   int         `json:"statusCode,omitempty"`
+	StaticAssets []JSONAsset `json:"staticAssets,omitempty"`
+}
+
+// NewJSONSiteMap converts the page tree into the flat JSON representation. Each page URL
+// appears only once in the list, in the order that they are found in the tree, represented by
+// the crawled page when the URL was also found in links that weren't followed. The URLs are
+// compared using the normalizer, that can be nil, and the links reference the URL of the page
+// listed for their address
+func NewJSONSiteMap(page *Page, normalizer Normalizer) JSONSiteMap {
+	siteMap := JSONSiteMap{
+		Root:  page.URL,
+		Pages: []JSONPage{},
+	}
+
+	for _, seed := range page.Seeds {
+		if seed.Sitemap {
+			siteMap.Seeds = append(siteMap.Seeds, seed.Href)
+		} else {
+			if len(siteMap.Roots) == 0 {
+				siteMap.Roots = []string{page.URL}
+			}
+			siteMap.Roots = append(siteMap.Roots, seed.Href)
+		}
+	}
+
+	pages := distinctPages(page, normalizer)
+	urls := make(map[string]string)
+	for _, p := range pages {
+		urls[normalizeURL(normalizer, p.URL)] = p.URL
+	}
+
+	for _, p := range pages {
+		jsonPage := JSONPage{
+			URL:           p.URL,
+			FinalURL:      p.FinalURL,
+			Canonical:     p.Canonical,
+			Depth:         p.Depth,
+			External:      p.External,
+			Fail:          p.Fail,
+			Error:         p.Error,
+			Skipped:       p.Skipped,
+			Document:      p.Document,
+			Noindex:       p.Noindex,
+			Nofollow:      p.Nofollow,
+			StatusCode:    p.StatusCode,
+			ContentType:   p.ContentType,
+			ContentLength: p.ContentLength,
+			Duration:      p.Duration.Nanoseconds() / 1e6,
+			Attempts:      p.Attempts,
+		}
+
+		for _, redirect := range p.Redirects {
+			jsonPage.Redirects = append(jsonPage.Redirects, JSONRedirect{
+				URL:        redirect.URL,
+				StatusCode: redirect.StatusCode,
+				Location:   redirect.Location,
+			})
+		}
+
+		for _, link := range p.Links {
+			jsonLink := JSONLink{
+				Label:        link.Label,
+				Href:         link.Href,
+				Nofollow:     link.Nofollow,
+				Cyclic:       link.CyclicPage,
+				Refresh:      link.Refresh,
+				RefreshDelay: link.RefreshDelay.Nanoseconds() / 1e6,
+			}
+
+			if link.Page != nil {
+				jsonLink.URL = urls[normalizeURL(normalizer, link.Page.URL)]
+			}
+
+			jsonPage.Links = append(jsonPage.Links, jsonLink)
+		}
+
+		jsonPage.StaticAssets = newJSONAssets(p.StaticAssets, p.AssetChecks)
+		siteMap.Pages = append(siteMap.Pages, jsonPage)
+	}
+
+	walkResources(page, func(r *Resource) {
+		if !r.Stylesheet {
+			return
+		}
+
+		siteMap.Stylesheets = append(siteMap.Stylesheets, JSONStylesheet{
+			URL:          r.URL,
+			StatusCode:   r.StatusCode,
+			StaticAssets: newJSONAssets(r.StaticAssets, r.AssetChecks),
+		})
+	})
+
+	return siteMap
+}
+
+// newJSONAssets converts the static assets, adding the availability information of the
+// checked ones
+func newJSONAssets(assets []Asset, checks map[string]*Resource) []JSONAsset {
+	var jsonAssets []JSONAsset
+	for _, asset := range assets {
+		jsonAsset := JSONAsset{
+			URL:         asset.URL,
+			Raw:         asset.Raw,
+			Kind:        asset.Kind,
+			Tag:         asset.Tag,
+			Attribute:   asset.Attribute,
+			Rel:         asset.Rel,
+			Type:        asset.Type,
+			Integrity:   asset.Integrity,
+			CrossOrigin: asset.CrossOrigin,
+		}
+
+		if resource := checks[asset.URL]; resource != nil {
+			jsonAsset.StatusCode = resource.StatusCode
+			jsonAsset.Fail = resource.Fail
+			jsonAsset.Error = resource.Error
+		}
+
+		jsonAssets = append(jsonAssets, jsonAsset)
+	}
+	return jsonAssets
+}
+
+// WriteJSON writes the JSON representation of the page tree (see NewJSONSiteMap)
+func WriteJSON(w io.Writer, page *Page, normalizer Normalizer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(NewJSONSiteMap(page, normalizer))
+}
@@ -0,0 +1,255 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewJSONSiteMap(t *testing.T) {
+	page := &Page{
+		URL:         "http://example.com",
+		StatusCode:  200,
+		ContentType: "text/html",
+		Duration:    1500 * time.Millisecond,
+		StaticAssets: []Asset{
+			{
+				URL:         "http://example.com/style.css",
+				Raw:         "style.css",
+				Kind:        AssetStylesheet,
+				Tag:         "link",
+				Attribute:   "href",
+				Rel:         "stylesheet",
+				Integrity:   "sha384-abc",
+				CrossOrigin: "anonymous",
+			},
+			{URL: "http://example.com/logo.png", Kind: AssetImage},
+		},
+		AssetChecks: map[string]*Resource{
+			"http://example.com/logo.png": &Resource{
+				URL:        "http://example.com/logo.png",
+				StatusCode: 404,
+				Fail:       true,
+			},
+			"http://example.com/style.css": &Resource{
+				URL:        "http://example.com/style.css",
+				StatusCode: 200,
+				Stylesheet: true,
+				StaticAssets: []Asset{
+					{URL: "http://example.com/logo.png", Raw: "logo.png", Kind: AssetImage},
+				},
+			},
+		},
+	}
+	page.AssetChecks["http://example.com/style.css"].AssetChecks = map[string]*Resource{
+		"http://example.com/logo.png": page.AssetChecks["http://example.com/logo.png"],
+	}
+
+	about := &Page{
+		URL:   "http://example.com/about.html",
+		Depth: 1,
+		Links: []Link{
+			{Label: "Home", Href: "/", Page: page, CyclicPage: true},
+			{Label: "Deep", Href: "/deep.html", Page: &Page{
+				URL:     "http://example.com/deep.html",
+				Depth:   2,
+				Skipped: SkipMaxDepth,
+			}},
+		},
+	}
+
+	external := &Page{URL: "http://example.net", Depth: 1, External: true, Fail: true,
+		Error: "timeout"}
+
+	page.Links = []Link{
+		{Label: "About", Href: "about.html", Page: about},
+		{Label: "External", Href: "http://example.net", Page: external},
+		{Label: "Anchor"},
+	}
+
+	page.Seeds = []Link{
+		{Label: "<start page>", Href: "http://example.com/about.html", Page: about, CyclicPage: true},
+		{Label: "<sitemap>", Href: "http://example.com/about.html", Sitemap: true, Page: about,
+			CyclicPage: true},
+		{Label: "<sitemap>", Href: "http://example.com/orphan.html", Sitemap: true, Page: &Page{
+			URL:        "http://example.com/orphan.html",
+			StatusCode: 200,
+		}},
+	}
+
+	expected := JSONSiteMap{
+		Root: "http://example.com",
+		Pages: []JSONPage{
+			{
+				URL:         "http://example.com",
+				StatusCode:  200,
+				ContentType: "text/html",
+				Duration:    1500,
+				Links: []JSONLink{
+					{Label: "About", Href: "about.html", URL: "http://example.com/about.html"},
+					{Label: "External", Href: "http://example.net", URL: "http://example.net"},
+					{Label: "Anchor"},
+				},
+				StaticAssets: []JSONAsset{
+					{
+						URL:         "http://example.com/style.css",
+						Raw:         "style.css",
+						Kind:        AssetStylesheet,
+						Tag:         "link",
+						Attribute:   "href",
+						Rel:         "stylesheet",
+						Integrity:   "sha384-abc",
+						CrossOrigin: "anonymous",
+						StatusCode:  200,
+					},
+					{URL: "http://example.com/logo.png", Kind: AssetImage, StatusCode: 404, Fail: true},
+				},
+			},
+			{
+				URL:   "http://example.com/about.html",
+				Depth: 1,
+				Links: []JSONLink{
+					{Label: "Home", Href: "/", URL: "http://example.com", Cyclic: true},
+					{Label: "Deep", Href: "/deep.html", URL: "http://example.com/deep.html"},
+				},
+			},
+			{
+				URL:     "http://example.com/deep.html",
+				Depth:   2,
+				Skipped: SkipMaxDepth,
+			},
+			{
+				URL:      "http://example.net",
+				Depth:    1,
+				External: true,
+				Fail:     true,
+				Error:    "timeout",
+			},
+			{
+				URL:        "http://example.com/orphan.html",
+				StatusCode: 200,
+			},
+		},
+		Stylesheets: []JSONStylesheet{
+			{
+				URL:        "http://example.com/style.css",
+				StatusCode: 200,
+				StaticAssets: []JSONAsset{
+					{
+						URL:        "http://example.com/logo.png",
+						Raw:        "logo.png",
+						Kind:       AssetImage,
+						StatusCode: 404,
+						Fail:       true,
+					},
+				},
+			},
+		},
+		Roots: []string{"http://example.com", "http://example.com/about.html"},
+		Seeds: []string{"http://example.com/about.html", "http://example.com/orphan.html"},
+	}
+
+	siteMap := NewJSONSiteMap(page, nil)
+	if !reflect.DeepEqual(siteMap, expected) {
+		t.Errorf("Unexpected JSON site map. Expected '%+v' and got '%+v'", expected, siteMap)
+	}
+}
+
+func TestNewJSONSiteMapMustPreferCrawledPages(t *testing.T) {
+	b := &Page{
+		URL:   "http://example.com/b",
+		Depth: 2,
+		Links: []Link{
+			{Label: "D", Href: "/d", Page: &Page{URL: "http://example.com/d", Depth: 3}},
+		},
+	}
+
+	page := &Page{
+		URL: "http://example.com/",
+		Links: []Link{
+			{Label: "B", Href: "/b#top", Nofollow: true, Page: &Page{
+				URL:     "http://example.com/b#top",
+				Depth:   1,
+				Skipped: SkipNofollow,
+			}},
+			{Label: "C", Href: "/c", Page: &Page{
+				URL:   "http://example.com/c",
+				Depth: 1,
+				Links: []Link{{Label: "B", Href: "/b", Page: b}},
+			}},
+		},
+	}
+
+	// Addresses that are the same page after the normalization are listed only once
+	var urls []string
+	siteMap := NewJSONSiteMap(page, NewURLNormalizer())
+	for _, p := range siteMap.Pages {
+		urls = append(urls, p.URL)
+
+		if p.URL == "http://example.com/b" && (len(p.Skipped) > 0 || len(p.Links) != 1) {
+			t.Errorf("Skipped page preferred over the crawled one: %+v", p)
+		}
+	}
+
+	expected := []string{
+		"http://example.com/",
+		"http://example.com/b",
+		"http://example.com/c",
+		"http://example.com/d",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Unexpected pages. Expected '%v' and got '%v'", expected, urls)
+	}
+
+	if link := siteMap.Pages[0].Links[0]; link.URL != "http://example.com/b" || link.Href != "/b#top" {
+		t.Errorf("Unexpected link to the normalized page: %+v", link)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	page := &Page{
+		URL: "http://example.com",
+	}
+	page.Links = []Link{
+		{Label: "Home", Href: "/", Page: page, CyclicPage: true},
+	}
+
+	var output bytes.Buffer
+	if err := WriteJSON(&output, page, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+  "root": "http://example.com",
+  "pages": [
+    {
+      "url": "http://example.com",
+      "depth": 0,
+      "links": [
+        {
+          "label": "Home",
+          "href": "/",
+          "url": "http://example.com",
+          "cyclic": true
+        }
+      ]
+    }
+  ]
+}
+`
+
+	if output.String() != expected {
+		t.Errorf("Unexpected JSON output. Expected %s and got %s", expected, output.String())
+	}
+
+	var siteMap JSONSiteMap
+	if err := json.Unmarshal(output.Bytes(), &siteMap); err != nil {
+		t.Errorf("JSON output can't be decoded: %s", err)
+	}
+}
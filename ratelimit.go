@@ -0,0 +1,209 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostLimits controls how the requests to the same host are distributed over time, to avoid
+// overloading a site. Zero values mean that there's no limit
+type HostLimits struct {
+	RequestsPerSecond float64       // Maximum rate of requests started for a host
+	MaxConcurrency    int           // Maximum number of requests running for a host at the same time
+	Jitter            time.Duration // Maximum random delay added before each request
+}
+
+// RateLimitedFetcher is a Fetcher that respects the HostLimits for each host before delegating
+// the request to another Fetcher. As it sits between the crawler and the real fetcher, both
+// page fetches and resource checks are limited
+type RateLimitedFetcher struct {
+	Fetcher Fetcher    // Fetcher that really retrieves the data
+	Limits  HostLimits // Limits applied independently for each host
+
+	// hosts stores the state of the limits of each host, indexed by the host name in lower
+	// case. The hostsLock allows it to be manipulated safely by go routines
+	hosts     map[string]*hostLimiter
+	hostsLock sync.Mutex
+}
+
+// hostLimiter stores the state of the limits of a single host
+type hostLimiter struct {
+	// slots controls the number of running requests, it's nil when there's no concurrency limit
+	slots chan struct{}
+
+	// next is the moment when the next request can start, respecting the maximum rate. The
+	// nextLock allows it to be manipulated safely by go routines
+	next     time.Time
+	nextLock sync.Mutex
+}
+
+// NewRateLimitedFetcher make it easy to wrap a fetcher with the limits for each host
+func NewRateLimitedFetcher(fetcher Fetcher, limits HostLimits) *RateLimitedFetcher {
+	return &RateLimitedFetcher{
+		Fetcher: fetcher,
+		Limits:  limits,
+		hosts:   make(map[string]*hostLimiter),
+	}
+}
+
+// Fetch retrieves the data of the URL when the limits of the host allow it
+func (f *RateLimitedFetcher) Fetch(url string) (*Response, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext retrieves the data of the URL when the limits of the host allow it. If ctx is
+// done while waiting the request isn't sent and the ctx error is returned
+func (f *RateLimitedFetcher) FetchContext(ctx context.Context, url string) (*Response, error) {
+	release, err := f.wait(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := fetchContext(ctx, f.Fetcher, url)
+	if err != nil || response == nil {
+		release()
+		return response, err
+	}
+
+	// Streamed content is still using the connection with the host, so the slot is only freed
+	// when the content is closed
+	if closer, ok := response.Body.(io.Closer); ok {
+		response.Body = &releaseBody{
+			Reader:  response.Body,
+			closer:  closer,
+			release: release,
+		}
+	} else {
+		release()
+	}
+
+	return response, nil
+}
+
+// Check verifies the availability of the URL when the limits of the host allow it. When the
+// wrapped fetcher isn't a Checker the content is retrieved and discarded
+func (f *RateLimitedFetcher) Check(ctx context.Context, url string) (*Response, error) {
+	release, err := f.wait(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if checker, ok := f.Fetcher.(Checker); ok {
+		return checker.Check(ctx, url)
+	}
+
+	return fetchContext(ctx, f.Fetcher, url)
+}
+
+// wait blocks until a request to the host of the URL can be sent. The returned function must
+// be called when the request finishes, to free the slot of the host
+func (f *RateLimitedFetcher) wait(ctx context.Context, rawURL string) (func(), error) {
+	limiter := f.limiter(rawURL)
+
+	release := func() {}
+	if limiter.slots != nil {
+		select {
+		case limiter.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		release = func() {
+			<-limiter.slots
+		}
+	}
+
+	now := time.Now()
+	startAt := now
+
+	if f.Limits.RequestsPerSecond > 0 {
+		interval := time.Duration(float64(time.Second) / f.Limits.RequestsPerSecond)
+
+		limiter.nextLock.Lock()
+		if limiter.next.After(startAt) {
+			startAt = limiter.next
+		}
+		limiter.next = startAt.Add(interval)
+		limiter.nextLock.Unlock()
+	}
+
+	if f.Limits.Jitter > 0 {
+		startAt = startAt.Add(time.Duration(rand.Int63n(int64(f.Limits.Jitter))))
+	}
+
+	if delay := startAt.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+// limiter is a go routine safe way to retrieve the state of the limits of the URL host,
+// creating it when it doesn't exist yet. URLs that can't be parsed share the same state
+func (f *RateLimitedFetcher) limiter(rawURL string) *hostLimiter {
+	var host string
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+
+	f.hostsLock.Lock()
+	defer f.hostsLock.Unlock()
+
+	if f.hosts == nil {
+		f.hosts = make(map[string]*hostLimiter)
+	}
+
+	limiter, found := f.hosts[host]
+	if !found {
+		limiter = new(hostLimiter)
+		if f.Limits.MaxConcurrency > 0 {
+			limiter.slots = make(chan struct{}, f.Limits.MaxConcurrency)
+		}
+		f.hosts[host] = limiter
+	}
+
+	return limiter
+}
+
+// releaseBody is the content of a response that frees the slot of the host when it's closed
+type releaseBody struct {
+	io.Reader
+
+	closer  io.Closer
+	release func()
+	once    sync.Once
+}
+
+// Close closes the original content and frees the slot of the host
+func (b *releaseBody) Close() error {
+	err := b.closer.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// fetchContext retrieves the data of the URL using ctx when the fetcher supports it
+func fetchContext(ctx context.Context, fetcher Fetcher, url string) (*Response, error) {
+	if contextFetcher, ok := fetcher.(ContextFetcher); ok {
+		return contextFetcher.FetchContext(ctx, url)
+	}
+
+	return fetcher.Fetch(url)
+}
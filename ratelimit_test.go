@@ -0,0 +1,165 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedFetcherMustLimitConcurrencyPerHost(t *testing.T) {
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	var lock sync.Mutex
+
+	fetcher := NewRateLimitedFetcher(FakeFetcher(func(url string) (*Response, error) {
+		host := strings.Split(url, "/")[2]
+
+		lock.Lock()
+		running[host]++
+		if running[host] > maxRunning[host] {
+			maxRunning[host] = running[host]
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running[host]--
+		lock.Unlock()
+
+		return htmlResponse(url, ""), nil
+	}), HostLimits{MaxConcurrency: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, host := range []string{"example.com", "example.net"} {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				if _, err := fetcher.Fetch(url); err != nil {
+					t.Error(err)
+				}
+			}(fmt.Sprintf("http://%s/%d", host, i))
+		}
+	}
+	wg.Wait()
+
+	for _, host := range []string{"example.com", "example.net"} {
+		if maxRunning[host] != 2 {
+			t.Errorf("Unexpected concurrent fetches for host %s. Expected 2 and got %d",
+				host, maxRunning[host])
+		}
+	}
+}
+
+func TestRateLimitedFetcherMustLimitRequestsPerSecond(t *testing.T) {
+	var requests []time.Time
+	var lock sync.Mutex
+
+	record := func(url string) (*Response, error) {
+		lock.Lock()
+		requests = append(requests, time.Now())
+		lock.Unlock()
+		return &Response{URL: url, StatusCode: http.StatusOK}, nil
+	}
+
+	fetcher := NewRateLimitedFetcher(FakeCheckerFetcher{
+		FakeFetcher: record,
+		check:       record,
+	}, HostLimits{RequestsPerSecond: 50, Jitter: time.Millisecond})
+
+	begin := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Checks and fetches share the limits of the host
+			url := fmt.Sprintf("http://example.com/%d", i)
+			if i%2 == 0 {
+				fetcher.Check(context.Background(), url)
+			} else {
+				fetcher.Fetch(url)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(requests) != 6 {
+		t.Fatalf("Unexpected number of requests. Expected 6 and got %d", len(requests))
+	}
+
+	// 6 requests with an interval of 20ms between them need at least 100ms
+	if elapsed := time.Since(begin); elapsed < 100*time.Millisecond {
+		t.Errorf("Requests per second not respected. All requests sent in %s", elapsed)
+	}
+}
+
+func TestRateLimitedFetcherMustStopOnCancel(t *testing.T) {
+	var fetches int
+	fetcher := NewRateLimitedFetcher(FakeFetcher(func(url string) (*Response, error) {
+		fetches++
+		return htmlResponse(url, ""), nil
+	}), HostLimits{RequestsPerSecond: 0.1})
+
+	if _, err := fetcher.Fetch("http://example.com/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next request would only be allowed after 10 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := fetcher.FetchContext(ctx, "http://example.com/2"); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'",
+			context.DeadlineExceeded, err)
+	}
+
+	if fetches != 1 {
+		t.Errorf("Unexpected number of fetches. Expected 1 and got %d", fetches)
+	}
+}
+
+func TestRateLimitedFetcherMustReleaseOnClose(t *testing.T) {
+	body := &trackingBody{reader: strings.NewReader("<html></html>")}
+	fetcher := NewRateLimitedFetcher(FakeFetcher(func(url string) (*Response, error) {
+		response := htmlResponse(url, "")
+		response.Body = body
+		return response, nil
+	}), HostLimits{MaxConcurrency: 1})
+
+	response, err := fetcher.Fetch("http://example.com/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While the content isn't closed the slot of the host is still in use
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := fetcher.FetchContext(ctx, "http://example.com/2"); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'",
+			context.DeadlineExceeded, err)
+	}
+
+	closeBody(response)
+	closeBody(response)
+
+	if !body.closed {
+		t.Error("Original content not closed")
+	}
+
+	if _, err := fetcher.Fetch("http://example.com/3"); err != nil {
+		t.Errorf("Slot not released after closing the content: %s", err)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BrokenLink describes an URL that isn't available and all the places where it's referenced
+type BrokenLink struct {
+	URL        string      // Address of the unavailable page or static asset
+	StatusCode int         // HTTP status code of the response, zero when there was no response
+	Error      string      // Reason of the failure when it isn't an HTTP status code
+	References []Reference // Pages that reference the URL
+}
+
+// String transforms the broken link into text mode to print the results
+func (b BrokenLink) String() string {
+	reason := b.Error
+	if b.StatusCode > 0 {
+		reason = fmt.Sprintf("%d", b.StatusCode)
+	}
+
+	brokenLinkStr := fmt.Sprintf("✗ %s (%s)", b.URL, reason)
+	for _, reference := range b.References {
+		if reference.StaticAsset {
+			brokenLinkStr += fmt.Sprintf("\n  ▤  %s", reference.Page)
+		} else {
+			brokenLinkStr += fmt.Sprintf("\n  ↳ \"%s\" %s", reference.Label, reference.Page)
+		}
+	}
+
+	return brokenLinkStr
+}
+
+// Reference identifies where an URL was found
+type Reference struct {
+	Page        string // Address of the page (or stylesheet) that contains the reference
+	Label       string // Context identification of the link
+	StaticAsset bool   // Flag to indicate that the URL is a static dependency of the page
+}
+
+// BrokenLinks travels the page tree looking for links and static assets that aren't
+// available, grouping them by the target URL. Static assets are only reported when they were
+// checked (see CrawlOptions.CheckResources). The result is sorted by URL
+func BrokenLinks(page *Page) []BrokenLink {
+	brokenLinks := make(map[string]*BrokenLink)
+
+	add := func(url string, statusCode int, err string, reference Reference) {
+		brokenLink, found := brokenLinks[url]
+		if !found {
+			brokenLink = &BrokenLink{
+				URL:        url,
+				StatusCode: statusCode,
+				Error:      err,
+			}
+			brokenLinks[url] = brokenLink
+		}
+
+		brokenLink.References = append(brokenLink.References, reference)
+	}
+
+	walkPages(page, func(p *Page) {
+		for _, link := range p.Links {
+			if link.Page != nil && link.Page.Fail {
+				add(link.Page.URL, link.Page.StatusCode, link.Page.Error, Reference{
+					Page:  p.URL,
+					Label: link.Label,
+				})
+			}
+		}
+
+		for _, staticAsset := range p.StaticAssets {
+			if resource := p.AssetChecks[staticAsset.URL]; resource != nil && resource.Fail {
+				add(resource.URL, resource.StatusCode, resource.Error, Reference{
+					Page:        p.URL,
+					StaticAsset: true,
+				})
+			}
+		}
+	})
+
+	// Dependencies of the analyzed stylesheets are referenced by the stylesheet itself
+	walkResources(page, func(r *Resource) {
+		for _, staticAsset := range r.StaticAssets {
+			if resource := r.AssetChecks[staticAsset.URL]; resource != nil && resource.Fail {
+				add(resource.URL, resource.StatusCode, resource.Error, Reference{
+					Page:        r.URL,
+					StaticAsset: true,
+				})
+			}
+		}
+	})
+
+	var urls []string
+	for url := range brokenLinks {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	var result []BrokenLink
+	for _, url := range urls {
+		result = append(result, *brokenLinks[url])
+	}
+	return result
+}
+
+// RedirectChain describes a page that is reached through redirects that deserve attention
+type RedirectChain struct {
+	URL         string     // Address of the page that was requested
+	FinalURL    string     // Address where the content was found
+	Redirects   []Redirect // Hops followed from the URL until the final URL
+	TooLong     bool       // Flag to indicate that there are more redirects than the accepted
+	LeavesScope bool       // Flag to indicate that the final URL is outside the scope of the crawl
+}
+
+// String transforms the redirect chain into text mode to print the results
+func (r RedirectChain) String() string {
+	var reasons []string
+	if r.TooLong {
+		reasons = append(reasons, fmt.Sprintf("%d redirects", len(r.Redirects)))
+	}
+	if r.LeavesScope {
+		reasons = append(reasons, "leaves the site")
+	}
+
+	redirectChainStr := fmt.Sprintf("↪ %s (%s)", r.URL, strings.Join(reasons, ", "))
+	for _, redirect := range r.Redirects {
+		redirectChainStr += fmt.Sprintf("\n  %d %s → %s", redirect.StatusCode, redirect.URL,
+			redirect.Location)
+	}
+
+	return redirectChainStr
+}
+
+// RedirectChains travels the page tree looking for pages of the site reached through more
+// than maxRedirects redirects, or that redirect to an address outside the scope. When the
+// scope is nil only the number of redirects is analyzed. The result is sorted by URL
+func RedirectChains(page *Page, maxRedirects int, scope *Scope) []RedirectChain {
+	var redirectChains []RedirectChain
+	urls := make(map[string]bool)
+
+	walkPages(page, func(p *Page) {
+		if p.External || len(p.Redirects) == 0 || urls[p.URL] {
+			return
+		}
+		urls[p.URL] = true
+
+		redirectChain := RedirectChain{
+			URL:       p.URL,
+			FinalURL:  p.FinalURL,
+			Redirects: p.Redirects,
+			TooLong:   len(p.Redirects) > maxRedirects,
+		}
+
+		if scope != nil && len(p.FinalURL) > 0 {
+			redirectChain.LeavesScope = !scope.Contains(p.FinalURL)
+		}
+
+		if redirectChain.TooLong || redirectChain.LeavesScope {
+			redirectChains = append(redirectChains, redirectChain)
+		}
+	})
+
+	sort.Slice(redirectChains, func(i, j int) bool {
+		return redirectChains[i].URL < redirectChains[j].URL
+	})
+	return redirectChains
+}
+
+// CanonicalIssue describes a page of the site whose canonical URL isn't the page address
+type CanonicalIssue struct {
+	URL       string   // Address where the page content was found
+	Canonical string   // Canonical URL declared by the page
+	Chain     []string // Other canonical URLs declared by the canonical page and its successors
+	Loop      bool     // Flag to indicate that the chain of canonical URLs returns to a visited one
+}
+
+// String transforms the canonical issue into text mode to print the results
+func (c CanonicalIssue) String() string {
+	canonicalIssueStr := fmt.Sprintf("≠ %s\n  → %s", c.URL, c.Canonical)
+	for _, canonical := range c.Chain {
+		canonicalIssueStr += fmt.Sprintf("\n  → %s", canonical)
+	}
+
+	if c.Loop {
+		canonicalIssueStr += " ↺"
+	}
+
+	return canonicalIssueStr
+}
+
+// CanonicalIssues travels the page tree looking for pages of the site that declare a canonical
+// URL different from their own address. When the canonical page was crawled and declares
+// another canonical URL, the chain is followed. The addresses are compared using the
+// normalizer, that can be nil. The result is sorted by URL
+func CanonicalIssues(page *Page, normalizer Normalizer) []CanonicalIssue {
+	address := func(p *Page) string {
+		if len(p.FinalURL) > 0 {
+			return p.FinalURL
+		}
+		return p.URL
+	}
+
+	// Pages are indexed by all their addresses, to find the page of a canonical URL
+	pages := make(map[string]*Page)
+	walkPages(page, func(p *Page) {
+		if p.External || p.Fail {
+			return
+		}
+
+		pages[normalizeURL(normalizer, p.URL)] = p
+		if _, found := pages[normalizeURL(normalizer, address(p))]; !found {
+			pages[normalizeURL(normalizer, address(p))] = p
+		}
+	})
+
+	var canonicalIssues []CanonicalIssue
+	urls := make(map[string]bool)
+
+	walkPages(page, func(p *Page) {
+		if p.External || len(p.Canonical) == 0 || urls[address(p)] {
+			return
+		}
+		urls[address(p)] = true
+
+		if normalizeURL(normalizer, p.Canonical) == normalizeURL(normalizer, address(p)) {
+			return
+		}
+
+		canonicalIssue := CanonicalIssue{
+			URL:       address(p),
+			Canonical: p.Canonical,
+		}
+
+		visited := map[string]bool{
+			normalizeURL(normalizer, address(p)):  true,
+			normalizeURL(normalizer, p.Canonical): true,
+		}
+
+		next := pages[normalizeURL(normalizer, p.Canonical)]
+		for next != nil && len(next.Canonical) > 0 &&
+			normalizeURL(normalizer, next.Canonical) != normalizeURL(normalizer, address(next)) {
+
+			if visited[normalizeURL(normalizer, next.Canonical)] {
+				canonicalIssue.Chain = append(canonicalIssue.Chain, next.Canonical)
+				canonicalIssue.Loop = true
+				break
+			}
+			visited[normalizeURL(normalizer, next.Canonical)] = true
+
+			canonicalIssue.Chain = append(canonicalIssue.Chain, next.Canonical)
+			next = pages[normalizeURL(normalizer, next.Canonical)]
+		}
+
+		canonicalIssues = append(canonicalIssues, canonicalIssue)
+	})
+
+	sort.Slice(canonicalIssues, func(i, j int) bool {
+		return canonicalIssues[i].URL < canonicalIssues[j].URL
+	})
+	return canonicalIssues
+}
+
+// OrphanPages compares the pages found in the sitemaps of the site with the pages reached by
+// links from the start pages, returning the addresses listed in the sitemaps that aren't linked
+// by the site (see CrawlOptions.UseSitemaps). Links that weren't followed, like the nofollow
+// ones, also count as references. The addresses are compared using the normalizer, that can
+// be nil. The result is sorted by URL
+func OrphanPages(page *Page, normalizer Normalizer) []string {
+	linked := make(map[string]bool)
+	walkLinkedPages(page, func(p *Page) {
+		linked[normalizeURL(normalizer, p.URL)] = true
+		if len(p.FinalURL) > 0 {
+			linked[normalizeURL(normalizer, p.FinalURL)] = true
+		}
+	})
+
+	var orphanPages []string
+	for _, seed := range page.Seeds {
+		if seed.Sitemap && !linked[normalizeURL(normalizer, seed.Href)] {
+			orphanPages = append(orphanPages, seed.Href)
+		}
+	}
+
+	sort.Strings(orphanPages)
+	return orphanPages
+}
+
+// MissingFromSitemap compares the pages reached by links from the start pages with the pages
+// found in the sitemaps of the site, returning the addresses of the pages that should be, but
+// aren't, listed in the sitemaps. The same pages of SitemapURLs are considered. When the crawl
+// didn't find any sitemap there's nothing to compare and nil is returned. The addresses are
+// compared using the normalizer, that can be nil. The result is sorted by URL
+func MissingFromSitemap(page *Page, scope *Scope, normalizer Normalizer) []string {
+	listed := make(map[string]bool)
+	for _, seed := range page.Seeds {
+		if seed.Sitemap {
+			listed[normalizeURL(normalizer, seed.Href)] = true
+		}
+	}
+
+	if len(listed) == 0 {
+		return nil
+	}
+
+	var missingPages []string
+	urls := make(map[string]bool)
+
+	walkLinkedPages(page, func(p *Page) {
+		loc, ok := sitemapLoc(p, scope)
+		if !ok || urls[loc] || listed[normalizeURL(normalizer, p.URL)] ||
+			listed[normalizeURL(normalizer, loc)] {
+			return
+		}
+		urls[loc] = true
+
+		missingPages = append(missingPages, loc)
+	})
+
+	sort.Strings(missingPages)
+	return missingPages
+}
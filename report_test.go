@@ -0,0 +1,374 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestBrokenLinks(t *testing.T) {
+	missing := &Page{URL: "http://example.com/missing.html", Fail: true, StatusCode: 404}
+	external := &Page{URL: "http://example.net", External: true, Fail: true, Error: "timeout"}
+	brokenImage := &Resource{URL: "http://example.com/logo.png", Fail: true, StatusCode: 500}
+
+	about := &Page{
+		URL: "http://example.com/about.html",
+		Links: []Link{
+			{Label: "Missing again", Page: missing},
+			{Label: "External", Page: external},
+		},
+		StaticAssets: []Asset{{URL: "http://example.com/logo.png"}},
+		AssetChecks: map[string]*Resource{
+			"http://example.com/logo.png": brokenImage,
+		},
+	}
+
+	page := &Page{
+		URL: "http://example.com",
+		Links: []Link{
+			{Label: "About", Page: about},
+			{Label: "Missing", Page: missing},
+			{Label: "No href"},
+		},
+		StaticAssets: []Asset{{URL: "http://example.com/logo.png"}, {URL: "http://example.com/style.css"}},
+		AssetChecks: map[string]*Resource{
+			"http://example.com/logo.png":  brokenImage,
+			"http://example.com/style.css": &Resource{URL: "http://example.com/style.css"},
+		},
+	}
+
+	// Create a cycle to make sure that the pages are analyzed only once
+	about.Links = append(about.Links, Link{Label: "Home", Page: page, CyclicPage: true})
+
+	expected := []BrokenLink{
+		{
+			URL:        "http://example.com/logo.png",
+			StatusCode: 500,
+			References: []Reference{
+				{Page: "http://example.com", StaticAsset: true},
+				{Page: "http://example.com/about.html", StaticAsset: true},
+			},
+		},
+		{
+			URL:        "http://example.com/missing.html",
+			StatusCode: 404,
+			References: []Reference{
+				{Page: "http://example.com", Label: "Missing"},
+				{Page: "http://example.com/about.html", Label: "Missing again"},
+			},
+		},
+		{
+			URL:   "http://example.net",
+			Error: "timeout",
+			References: []Reference{
+				{Page: "http://example.com/about.html", Label: "External"},
+			},
+		},
+	}
+
+	brokenLinks := BrokenLinks(page)
+	if len(brokenLinks) != len(expected) {
+		t.Fatalf("Unexpected number of broken links. Expected %d and got %d: %v",
+			len(expected), len(brokenLinks), brokenLinks)
+	}
+
+	for i := range expected {
+		// The references order depends on the page tree travel order, so we sort them before
+		// comparing
+		sortReferences(brokenLinks[i].References)
+
+		if !reflect.DeepEqual(brokenLinks[i], expected[i]) {
+			t.Errorf("Unexpected broken link. Expected '%+v' and got '%+v'",
+				expected[i], brokenLinks[i])
+		}
+	}
+
+	if BrokenLinks(&Page{URL: "http://example.com"}) != nil {
+		t.Error("Broken links found on a page without links")
+	}
+}
+
+func TestBrokenLinkString(t *testing.T) {
+	brokenLink := BrokenLink{
+		URL:        "http://example.com/missing.html",
+		StatusCode: 404,
+		References: []Reference{
+			{Page: "http://example.com", Label: "Missing"},
+			{Page: "http://example.com/about.html", StaticAsset: true},
+		},
+	}
+
+	expected := `✗ http://example.com/missing.html (404)
+  ↳ "Missing" http://example.com
+  ▤  http://example.com/about.html`
+
+	if brokenLink.String() != expected {
+		t.Errorf("Broken link text format was different from the expected. Expected %s and got %s",
+			expected, brokenLink)
+	}
+
+	brokenLink.StatusCode = 0
+	brokenLink.Error = "connection refused"
+	brokenLink.References = nil
+
+	expected = "✗ http://example.com/missing.html (connection refused)"
+	if brokenLink.String() != expected {
+		t.Errorf("Broken link text format was different from the expected. Expected %s and got %s",
+			expected, brokenLink)
+	}
+}
+
+// sortReferences orders the references by page address
+func sortReferences(references []Reference) {
+	sort.Slice(references, func(i, j int) bool {
+		return references[i].Page < references[j].Page
+	})
+}
+
+func TestRedirectChains(t *testing.T) {
+	page := &Page{
+		URL: "http://example.com",
+		Redirects: []Redirect{
+			{URL: "http://example.com", StatusCode: 301, Location: "https://example.com/"},
+		},
+		FinalURL: "https://example.com/",
+	}
+
+	page.Links = []Link{
+		{Label: "Long", Page: &Page{
+			URL:      "http://example.com/long.html",
+			FinalURL: "http://example.com/c.html",
+			Redirects: []Redirect{
+				{URL: "http://example.com/long.html", StatusCode: 301, Location: "http://example.com/a.html"},
+				{URL: "http://example.com/a.html", StatusCode: 302, Location: "http://example.com/b.html"},
+				{URL: "http://example.com/b.html", StatusCode: 307, Location: "http://example.com/c.html"},
+			},
+		}},
+		{Label: "Away", Page: &Page{
+			URL:      "http://example.com/away.html",
+			FinalURL: "http://example.net/",
+			Redirects: []Redirect{
+				{URL: "http://example.com/away.html", StatusCode: 302, Location: "http://example.net/"},
+			},
+		}},
+		{Label: "External", Page: &Page{
+			URL:      "http://example.org",
+			FinalURL: "http://example.org/",
+			External: true,
+			Redirects: []Redirect{
+				{URL: "http://example.org", StatusCode: 301, Location: "http://www.example.org/"},
+				{URL: "http://www.example.org", StatusCode: 301, Location: "http://example.org/"},
+			},
+		}},
+		{Label: "Home", Page: page, CyclicPage: true},
+	}
+
+	scope, err := NewScope("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redirectChains := RedirectChains(page, 2, scope)
+	if len(redirectChains) != 2 {
+		t.Fatalf("Unexpected number of redirect chains. Expected 2 and got %d: %v",
+			len(redirectChains), redirectChains)
+	}
+
+	expected := `↪ http://example.com/away.html (leaves the site)
+  302 http://example.com/away.html → http://example.net/`
+
+	if redirectChains[0].String() != expected {
+		t.Errorf("Unexpected redirect chain. Expected '%s' and got '%s'",
+			expected, redirectChains[0])
+	}
+
+	expected = `↪ http://example.com/long.html (3 redirects)
+  301 http://example.com/long.html → http://example.com/a.html
+  302 http://example.com/a.html → http://example.com/b.html
+  307 http://example.com/b.html → http://example.com/c.html`
+
+	if redirectChains[1].String() != expected {
+		t.Errorf("Unexpected redirect chain. Expected '%s' and got '%s'",
+			expected, redirectChains[1])
+	}
+
+	if redirectChains := RedirectChains(page, 0, nil); len(redirectChains) != 3 {
+		t.Errorf("Unexpected number of redirect chains without scope. Expected 3 and got %d",
+			len(redirectChains))
+	}
+}
+
+func TestCanonicalIssues(t *testing.T) {
+	page := &Page{
+		URL:       "http://example.com",
+		FinalURL:  "http://example.com/",
+		Canonical: "http://EXAMPLE.com/",
+	}
+
+	page.Links = []Link{
+		{Label: "Print", Page: &Page{
+			URL:       "http://example.com/print.html",
+			Canonical: "http://example.com/article.html",
+		}},
+		{Label: "Article", Page: &Page{
+			URL:       "http://example.com/article.html",
+			Canonical: "http://example.com/article.html",
+		}},
+		{Label: "Old", Page: &Page{
+			URL:       "http://example.com/old.html",
+			Canonical: "http://example.com/older.html",
+		}},
+		{Label: "Older", Page: &Page{
+			URL:       "http://example.com/older.html",
+			Canonical: "http://example.com/oldest.html",
+		}},
+		{Label: "Oldest", Page: &Page{
+			URL:       "http://example.com/oldest.html",
+			Canonical: "http://example.com/old.html",
+		}},
+		{Label: "External", Page: &Page{
+			URL:       "http://example.net",
+			External:  true,
+			Canonical: "http://example.org",
+		}},
+		{Label: "Home", Page: page, CyclicPage: true},
+	}
+
+	canonicalIssues := CanonicalIssues(page, NewURLNormalizer())
+
+	expected := []CanonicalIssue{
+		{
+			URL:       "http://example.com/old.html",
+			Canonical: "http://example.com/older.html",
+			Chain:     []string{"http://example.com/oldest.html", "http://example.com/old.html"},
+			Loop:      true,
+		},
+		{
+			URL:       "http://example.com/older.html",
+			Canonical: "http://example.com/oldest.html",
+			Chain:     []string{"http://example.com/old.html", "http://example.com/older.html"},
+			Loop:      true,
+		},
+		{
+			URL:       "http://example.com/oldest.html",
+			Canonical: "http://example.com/old.html",
+			Chain:     []string{"http://example.com/older.html", "http://example.com/oldest.html"},
+			Loop:      true,
+		},
+		{
+			URL:       "http://example.com/print.html",
+			Canonical: "http://example.com/article.html",
+		},
+	}
+
+	if !reflect.DeepEqual(canonicalIssues, expected) {
+		t.Fatalf("Unexpected canonical issues. Expected '%v' and got '%v'",
+			expected, canonicalIssues)
+	}
+
+	expectedStr := `≠ http://example.com/old.html
+  → http://example.com/older.html
+  → http://example.com/oldest.html
+  → http://example.com/old.html ↺`
+
+	if canonicalIssues[0].String() != expectedStr {
+		t.Errorf("Unexpected canonical issue. Expected '%s' and got '%s'",
+			expectedStr, canonicalIssues[0])
+	}
+
+	// Without normalizer the address of the start page is different from its canonical URL
+	if canonicalIssues := CanonicalIssues(page, nil); len(canonicalIssues) != 5 {
+		t.Errorf("Unexpected number of canonical issues without normalizer. Expected 5 and "+
+			"got %d", len(canonicalIssues))
+	}
+}
+
+func TestSitemapCoverage(t *testing.T) {
+	page := &Page{
+		URL:      "http://example.com",
+		FinalURL: "http://example.com/",
+	}
+
+	about := &Page{URL: "http://example.com/about.html"}
+	orphan := &Page{URL: "http://example.com/orphan.html"}
+
+	page.Links = []Link{
+		{Label: "About", Page: about},
+		{Label: "Contact", Page: &Page{URL: "http://example.com/contact.html"}},
+		{Label: "Old", Page: &Page{
+			URL:      "http://example.com/old.html",
+			FinalURL: "http://example.com/new.html",
+		}},
+		{Label: "Sponsor", Page: &Page{
+			URL:     "http://example.com/sponsor.html",
+			Skipped: SkipNofollow,
+		}},
+		{Label: "Private", Page: &Page{URL: "http://example.com/private.html", Noindex: true}},
+		{Label: "External", Page: &Page{URL: "http://example.net", External: true}},
+		{Label: "Home", Page: page, CyclicPage: true},
+	}
+
+	orphan.Links = []Link{
+		{Label: "Hidden", Page: &Page{URL: "http://example.com/hidden.html"}},
+	}
+
+	// Pages linked by other start pages are also reached by links
+	french := &Page{
+		URL: "http://example.com/fr/",
+		Links: []Link{
+			{Label: "À propos", Page: &Page{URL: "http://example.com/fr/about.html"}},
+		},
+	}
+
+	sitemapSeed := func(p *Page, cyclic bool) Link {
+		return Link{Label: "<sitemap>", Href: p.URL, Sitemap: true, Page: p, CyclicPage: cyclic}
+	}
+
+	page.Seeds = []Link{
+		{Label: "<start page>", Href: "http://example.com/fr/", Page: french},
+		{Label: "<sitemap>", Href: "http://EXAMPLE.com/", Sitemap: true, Page: page, CyclicPage: true},
+		sitemapSeed(about, true),
+		sitemapSeed(french.Links[0].Page, true),
+		sitemapSeed(&Page{URL: "http://example.com/new.html", Skipped: SkipDuplicate}, false),
+		sitemapSeed(&Page{URL: "http://example.com/sponsor.html"}, false),
+		sitemapSeed(orphan, false),
+	}
+
+	expectedOrphans := []string{"http://example.com/orphan.html"}
+	orphans := OrphanPages(page, NewURLNormalizer())
+	if !reflect.DeepEqual(orphans, expectedOrphans) {
+		t.Errorf("Unexpected orphan pages. Expected '%v' and got '%v'", expectedOrphans, orphans)
+	}
+
+	// Pages only linked by orphan pages aren't reached by links from the start pages, so they
+	// aren't missing from the sitemap
+	expectedMissing := []string{"http://example.com/contact.html", "http://example.com/fr/"}
+	missing := MissingFromSitemap(page, nil, NewURLNormalizer())
+	if !reflect.DeepEqual(missing, expectedMissing) {
+		t.Errorf("Unexpected pages missing from the sitemap. Expected '%v' and got '%v'",
+			expectedMissing, missing)
+	}
+
+	// Without normalizer the address of the start page is different from the sitemap entry
+	expectedMissing = []string{
+		"http://example.com/",
+		"http://example.com/contact.html",
+		"http://example.com/fr/",
+	}
+	missing = MissingFromSitemap(page, nil, nil)
+	if !reflect.DeepEqual(missing, expectedMissing) {
+		t.Errorf("Unexpected pages missing from the sitemap without normalizer. Expected '%v' "+
+			"and got '%v'", expectedMissing, missing)
+	}
+
+	// Without sitemaps there's nothing to compare
+	page.Seeds = page.Seeds[:1]
+	orphans, missing = OrphanPages(page, nil), MissingFromSitemap(page, nil, nil)
+	if orphans != nil || missing != nil {
+		t.Errorf("Unexpected coverage without sitemaps: '%v' and '%v'", orphans, missing)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how many times and how often a failed request is sent again
+type RetryPolicy struct {
+	MaxAttempts int           // Maximum number of requests sent for a URL, including the first one
+	BaseDelay   time.Duration // Delay before the first retry, doubled on each new retry
+	MaxDelay    time.Duration // Maximum delay between attempts, zero for no limit
+}
+
+// DefaultRetryPolicy is a policy that tolerates short instabilities of the servers
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// RetryError is returned when all attempts to retrieve a URL failed, storing the error of the
+// last attempt
+type RetryError struct {
+	Attempts int   // Number of requests sent
+	Err      error // Error of the last request
+}
+
+// Error shows the last error together with the number of attempts, when the request was sent
+// more than once
+func (e *RetryError) Error() string {
+	if e.Attempts <= 1 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s (after %d attempts)", e.Err, e.Attempts)
+}
+
+// Unwrap returns the error of the last request, so it can be inspected with errors.Is and
+// errors.As
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryFetcher is a Fetcher that sends the request again, using another Fetcher, when it fails
+// for a reason that could be temporary: network errors and the HTTP status codes 429, 502, 503
+// and 504. The interval between the attempts grows exponentially with a random jitter, unless
+// the server defines it with the Retry-After header. Only GET and HEAD requests are sent by the
+// fetchers, so retrying is always safe
+type RetryFetcher struct {
+	Fetcher Fetcher     // Fetcher that really retrieves the data
+	Policy  RetryPolicy // Number of attempts and interval between them
+}
+
+// NewRetryFetcher make it easy to wrap a fetcher with a retry policy
+func NewRetryFetcher(fetcher Fetcher, policy RetryPolicy) *RetryFetcher {
+	return &RetryFetcher{
+		Fetcher: fetcher,
+		Policy:  policy,
+	}
+}
+
+// Fetch retrieves the data of the URL, retrying on temporary failures
+func (f *RetryFetcher) Fetch(url string) (*Response, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext retrieves the data of the URL, retrying on temporary failures. No retry is sent
+// after ctx is done
+func (f *RetryFetcher) FetchContext(ctx context.Context, url string) (*Response, error) {
+	return f.retry(ctx, func() (*Response, error) {
+		return fetchContext(ctx, f.Fetcher, url)
+	})
+}
+
+// Check verifies the availability of the URL, retrying on temporary failures. When the wrapped
+// fetcher isn't a Checker the content is retrieved and discarded
+func (f *RetryFetcher) Check(ctx context.Context, url string) (*Response, error) {
+	return f.retry(ctx, func() (*Response, error) {
+		if checker, ok := f.Fetcher.(Checker); ok {
+			return checker.Check(ctx, url)
+		}
+		return fetchContext(ctx, f.Fetcher, url)
+	})
+}
+
+// retry sends the request until it succeeds, fails permanently or the attempts are over. The
+// number of attempts is stored in the returned response
+func (f *RetryFetcher) retry(ctx context.Context, request func() (*Response, error)) (*Response, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := request()
+
+		var retryAfter time.Duration
+		if err != nil {
+			if !temporaryError(err) || ctx.Err() != nil {
+				return nil, attemptsError(err, attempt)
+			}
+
+		} else {
+			response.Attempts = attempt
+			if !temporaryStatus(response.StatusCode) {
+				return response, nil
+			}
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+		}
+
+		if attempt >= f.Policy.MaxAttempts {
+			return response, attemptsError(err, attempt)
+		}
+
+		delay := f.backoff(attempt)
+		if retryAfter > 0 {
+			// When the server asks us to wait longer than we accept, we give up now instead of
+			// sending the request before the expected moment
+			if f.Policy.MaxDelay > 0 && retryAfter > f.Policy.MaxDelay {
+				return response, nil
+			}
+			delay = retryAfter
+		}
+
+		closeBody(response)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt. The delay is doubled on each attempt, and
+// a random value of up to half of the delay is subtracted to spread the retries of many
+// concurrent requests
+func (f *RetryFetcher) backoff(attempt int) time.Duration {
+	delay := f.Policy.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if f.Policy.MaxDelay > 0 && delay > f.Policy.MaxDelay {
+			break
+		}
+	}
+
+	if f.Policy.MaxDelay > 0 && delay > f.Policy.MaxDelay {
+		delay = f.Policy.MaxDelay
+	}
+
+	if half := int64(delay / 2); half > 0 {
+		delay -= time.Duration(rand.Int63n(half))
+	}
+	return delay
+}
+
+// attemptsError stores the number of attempts in the error, so a failed request records the
+// attempts in the same way of a successful one
+func attemptsError(err error, attempts int) error {
+	if err == nil {
+		return err
+	}
+	return &RetryError{Attempts: attempts, Err: err}
+}
+
+// temporaryError checks if the error was caused by a network problem that could not happen
+// again in a new attempt: timeouts, connections refused or reset and connections closed in
+// the middle of the response. Problems like invalid certificates, unsupported schemes, unknown
+// hosts and redirect loops are permanent
+func temporaryError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// All errors of the HTTP client are wrapped in an url.Error, that is always a net.Error, so
+	// only the original error is analyzed
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// temporaryStatus checks if the HTTP status code indicates a temporary problem of the server
+func temporaryStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter converts the value of the Retry-After header, in seconds or as an HTTP date,
+// into the duration to wait. Zero is returned when the header is empty or invalid
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
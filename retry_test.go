@@ -0,0 +1,339 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryFetcher(t *testing.T) {
+	connectionReset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	}
+
+	statusResponse := func(statusCode int, retryAfter string) *Response {
+		response := &Response{URL: "http://example.com", StatusCode: statusCode}
+		if len(retryAfter) > 0 {
+			response.Header = http.Header{"Retry-After": []string{retryAfter}}
+		}
+		return response
+	}
+
+	testData := []struct {
+		description        string
+		responses          []*Response
+		errs               []error
+		expectedAttempts   int
+		expectedStatusCode int
+		expectedErr        error
+	}{
+		{
+			description:        "success",
+			responses:          []*Response{statusResponse(http.StatusOK, "")},
+			errs:               []error{nil},
+			expectedAttempts:   1,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "network error",
+			responses:          []*Response{nil, statusResponse(http.StatusOK, "")},
+			errs:               []error{connectionReset, nil},
+			expectedAttempts:   2,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description: "temporary status codes",
+			responses: []*Response{
+				statusResponse(http.StatusServiceUnavailable, ""),
+				statusResponse(http.StatusBadGateway, ""),
+				statusResponse(http.StatusOK, ""),
+			},
+			errs:               []error{nil, nil, nil},
+			expectedAttempts:   3,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "permanent status code",
+			responses:          []*Response{statusResponse(http.StatusNotFound, "")},
+			errs:               []error{nil},
+			expectedAttempts:   1,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			description: "attempts over with status code",
+			responses: []*Response{
+				statusResponse(http.StatusGatewayTimeout, ""),
+				statusResponse(http.StatusGatewayTimeout, ""),
+				statusResponse(http.StatusGatewayTimeout, ""),
+			},
+			errs:               []error{nil, nil, nil},
+			expectedAttempts:   3,
+			expectedStatusCode: http.StatusGatewayTimeout,
+		},
+		{
+			description:      "attempts over with network error",
+			responses:        []*Response{nil, nil, nil},
+			errs:             []error{connectionReset, connectionReset, connectionReset},
+			expectedAttempts: 3,
+			expectedErr:      &RetryError{Attempts: 3, Err: connectionReset},
+		},
+		{
+			description:      "permanent error",
+			responses:        []*Response{nil},
+			errs:             []error{http.ErrContentLength},
+			expectedAttempts: 1,
+			expectedErr:      &RetryError{Attempts: 1, Err: http.ErrContentLength},
+		},
+		{
+			description:        "retry after too long",
+			responses:          []*Response{statusResponse(http.StatusTooManyRequests, "3600")},
+			errs:               []error{nil},
+			expectedAttempts:   1,
+			expectedStatusCode: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, testItem := range testData {
+		attempts := 0
+		fetcher := NewRetryFetcher(FakeFetcher(func(url string) (*Response, error) {
+			attempts++
+			if attempts > len(testItem.responses) {
+				t.Fatalf("Unexpected attempt on %s test", testItem.description)
+			}
+
+			// The response objects are reused, so we copy them to avoid sharing the attempts
+			var response *Response
+			if r := testItem.responses[attempts-1]; r != nil {
+				copied := *r
+				response = &copied
+			}
+			return response, testItem.errs[attempts-1]
+		}), policy)
+
+		response, err := fetcher.Fetch("http://example.com")
+		if fmt.Sprint(err) != fmt.Sprint(testItem.expectedErr) {
+			t.Errorf("Unexpected error on %s test. Expected '%v' and got '%v'",
+				testItem.description, testItem.expectedErr, err)
+		}
+
+		if attempts != testItem.expectedAttempts {
+			t.Errorf("Unexpected number of attempts on %s test. Expected %d and got %d",
+				testItem.description, testItem.expectedAttempts, attempts)
+		}
+
+		if testItem.expectedErr != nil {
+			continue
+		}
+
+		if response.StatusCode != testItem.expectedStatusCode ||
+			response.Attempts != testItem.expectedAttempts {
+			t.Errorf("Unexpected response on %s test. Expected status %d and %d attempts and "+
+				"got status %d and %d attempts", testItem.description, testItem.expectedStatusCode,
+				testItem.expectedAttempts, response.StatusCode, response.Attempts)
+		}
+	}
+}
+
+func TestRetryFetcherMustNotRetryPermanentErrors(t *testing.T) {
+	var requests int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/loop" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	httpTestServer := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer httpTestServer.Close()
+
+	testData := []struct {
+		description      string
+		client           *http.Client
+		url              string
+		expectedRequests int32
+	}{
+		{
+			description:      "redirect loop",
+			client:           httpTestServer.Client(),
+			url:              httpTestServer.URL + "/loop",
+			expectedRequests: 1,
+		},
+		{
+			description:      "unknown certificate authority",
+			client:           &http.Client{},
+			url:              httpTestServer.URL,
+			expectedRequests: 0,
+		},
+		{
+			description:      "unsupported scheme",
+			client:           &http.Client{},
+			url:              "ftp://example.com",
+			expectedRequests: 0,
+		},
+	}
+
+	for _, testItem := range testData {
+		atomic.StoreInt32(&requests, 0)
+		fetcher := NewRetryFetcher(HTTPFetcher{Client: testItem.client}, RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Millisecond,
+		})
+
+		_, err := fetcher.Fetch(testItem.url)
+
+		var retryErr *RetryError
+		if !errors.As(err, &retryErr) || retryErr.Attempts != 1 {
+			t.Errorf("Unexpected error on %s test. Expected a single attempt and got '%v'",
+				testItem.description, err)
+		}
+
+		if n := atomic.LoadInt32(&requests); n != testItem.expectedRequests {
+			t.Errorf("Unexpected number of requests on %s test. Expected %d and got %d",
+				testItem.description, testItem.expectedRequests, n)
+		}
+	}
+}
+
+func TestRetryFetcherMustHonourRetryAfter(t *testing.T) {
+	var requests []time.Time
+	fetcher := NewRetryFetcher(FakeCheckerFetcher{
+		check: func(url string) (*Response, error) {
+			requests = append(requests, time.Now())
+			if len(requests) == 1 {
+				return &Response{
+					URL:        url,
+					StatusCode: http.StatusServiceUnavailable,
+					Header:     http.Header{"Retry-After": []string{"1"}},
+				}, nil
+			}
+			return &Response{URL: url, StatusCode: http.StatusOK}, nil
+		},
+	}, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	response, err := fetcher.Check(context.Background(), "http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusOK || response.Attempts != 2 {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	if interval := requests[1].Sub(requests[0]); interval < time.Second {
+		t.Errorf("Retry-After not respected. Interval between attempts was %s", interval)
+	}
+}
+
+func TestRetryFetcherMustStopOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	fetcher := NewRetryFetcher(FakeFetcher(func(url string) (*Response, error) {
+		attempts++
+		return &Response{URL: url, StatusCode: http.StatusServiceUnavailable}, nil
+	}), RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute})
+
+	if _, err := fetcher.FetchContext(ctx, "http://example.com"); err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'",
+			context.DeadlineExceeded, err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("Unexpected number of attempts. Expected 1 and got %d", attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	testData := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: 0},
+		{value: "120", expected: 2 * time.Minute},
+		{value: "-1", expected: 0},
+		{value: "soon", expected: 0},
+		{value: "Wed, 21 Oct 2015 07:28:00 GMT", expected: 0},
+	}
+
+	for _, testItem := range testData {
+		if delay := parseRetryAfter(testItem.value); delay != testItem.expected {
+			t.Errorf("Unexpected delay for '%s'. Expected '%s' and got '%s'",
+				testItem.value, testItem.expected, delay)
+		}
+	}
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if delay := parseRetryAfter(date); delay < 59*time.Minute || delay > time.Hour {
+		t.Errorf("Unexpected delay for '%s'. Got '%s'", date, delay)
+	}
+}
+
+func TestCrawlMustRecordAttempts(t *testing.T) {
+	connectionReset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	attempts := make(map[string]int)
+	var attemptsLock sync.Mutex
+
+	fetcher := NewRetryFetcher(FakeFetcher(func(url string) (*Response, error) {
+		attemptsLock.Lock()
+		attempts[url]++
+		attempt := attempts[url]
+		attemptsLock.Unlock()
+
+		switch url {
+		case "http://example.com":
+			if attempt == 1 {
+				return nil, connectionReset
+			}
+			return htmlResponse(url, `<html><body>
+				<a href="/down.html">Down</a>
+				<a href="/invalid.html">Invalid</a>
+			</body></html>`), nil
+
+		case "http://example.com/down.html":
+			return nil, connectionReset
+
+		case "http://example.com/invalid.html":
+			return nil, http.ErrContentLength
+		}
+
+		return &Response{URL: url, StatusCode: http.StatusNotFound}, nil
+	}), RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	page, err := Crawl("http://example.com", fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected error returned. Expected '%v' and got '%v'", nil, err)
+	}
+
+	if page.Fail || page.Attempts != 2 {
+		t.Errorf("Unexpected page after retry: %+v", page)
+	}
+
+	down := page.Links[0].Page
+	if !down.Fail || down.Attempts != 2 || !strings.HasSuffix(down.Error, "(after 2 attempts)") {
+		t.Errorf("Unexpected failed page after retries: %+v", down)
+	}
+
+	invalid := page.Links[1].Page
+	if !invalid.Fail || invalid.Attempts != 1 || strings.Contains(invalid.Error, "attempts") {
+		t.Errorf("Unexpected failed page after a single attempt: %+v", invalid)
+	}
+}
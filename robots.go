@@ -0,0 +1,301 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"bufio"
+	"code.google.com/p/go.net/html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRobotsUserAgent is the product token used to select the rules of the robots.txt
+	// files when no other token is defined in the crawl options
+	DefaultRobotsUserAgent = "crawler"
+)
+
+// Robots stores the rules of a robots.txt file that apply to a specific user agent, as defined
+// in RFC 9309. A nil Robots allows everything
+type Robots struct {
+	Rules      []RobotsRule  // Allow and Disallow rules of the user agent
+	CrawlDelay time.Duration // Minimum interval between requests, zero when not defined
+	Sitemaps   []string      // Addresses of the sitemaps of the site, that apply to all user agents
+}
+
+// RobotsRule is an Allow or Disallow line of a robots.txt file
+type RobotsRule struct {
+	Allow   bool   // Flag to indicate that the paths matching the pattern can be crawled
+	Pattern string // Path pattern, where "*" matches any sequence and "$" the end of the path
+}
+
+// ParseRobots reads a robots.txt file, keeping only the rules of the groups that match the
+// user agent token (case insensitive). When there's no specific group for the user agent the
+// rules of the "*" group are used. Sitemap lines don't belong to any group and are always kept
+func ParseRobots(r io.Reader, userAgent string) (*Robots, error) {
+	userAgent = strings.ToLower(strings.TrimSpace(userAgent))
+
+	var specific, global Robots
+	var foundSpecific bool
+	var sitemaps []string
+
+	// A group starts with one or more user-agent lines. The current group could apply to the
+	// user agent, to all user agents, or to both when both tokens are listed
+	var groupSpecific, groupGlobal, readingAgents bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+
+		index := strings.Index(line, ":")
+		if index < 0 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(line[:index]))
+		value := strings.TrimSpace(line[index+1:])
+
+		// Sitemap lines can appear anywhere and don't end the list of user agents of a group
+		if key == "sitemap" {
+			if len(value) > 0 {
+				sitemaps = append(sitemaps, value)
+			}
+			continue
+		}
+
+		if key == "user-agent" {
+			if !readingAgents {
+				groupSpecific, groupGlobal = false, false
+				readingAgents = true
+			}
+
+			agent := strings.ToLower(value)
+			if agent == "*" {
+				groupGlobal = true
+			} else if len(userAgent) > 0 && agent == userAgent {
+				groupSpecific = true
+				foundSpecific = true
+			}
+			continue
+		}
+		readingAgents = false
+
+		var rule *RobotsRule
+		var crawlDelay time.Duration
+
+		switch key {
+		case "allow", "disallow":
+			// An empty Disallow means that everything is allowed, so there's no rule
+			if len(value) == 0 {
+				continue
+			}
+			rule = &RobotsRule{Allow: key == "allow", Pattern: value}
+
+		case "crawl-delay":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			crawlDelay = time.Duration(seconds * float64(time.Second))
+
+		default:
+			continue
+		}
+
+		for _, group := range []struct {
+			matches bool
+			robots  *Robots
+		}{
+			{groupSpecific, &specific},
+			{groupGlobal, &global},
+		} {
+			if !group.matches {
+				continue
+			}
+
+			if rule != nil {
+				group.robots.Rules = append(group.robots.Rules, *rule)
+			} else {
+				group.robots.CrawlDelay = crawlDelay
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	specific.Sitemaps = sitemaps
+	global.Sitemaps = sitemaps
+
+	if foundSpecific {
+		return &specific, nil
+	}
+	return &global, nil
+}
+
+// Allowed checks if the path (with the query string) can be crawled. The rule with the
+// longest matching pattern wins, and when an Allow and a Disallow rule have the same length
+// the Allow rule is used. The robots.txt file itself is always allowed
+func (r *Robots) Allowed(path string) bool {
+	if r == nil || path == "/robots.txt" {
+		return true
+	}
+
+	allowed := true
+	length := -1
+
+	for _, rule := range r.Rules {
+		if !matchRobotsPattern(rule.Pattern, path) {
+			continue
+		}
+
+		if len(rule.Pattern) > length || (len(rule.Pattern) == length && rule.Allow) {
+			allowed = rule.Allow
+			length = len(rule.Pattern)
+		}
+	}
+
+	return allowed
+}
+
+// matchRobotsPattern checks if the path starts with the pattern, where "*" matches any
+// sequence of characters and a "$" in the end of the pattern matches the end of the path
+func matchRobotsPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+
+	if len(parts) == 1 {
+		return !anchored || len(path) == 0
+	}
+
+	// Matching the middle parts as early as possible leaves more characters for the next parts
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(path, part)
+		if index < 0 {
+			return false
+		}
+		path = path[index+len(part):]
+	}
+
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(path, last)
+	}
+	return strings.Contains(path, last)
+}
+
+// RobotsDirectives are the indexing rules that a page defines for the crawlers, using the
+// robots <meta> element or the X-Robots-Tag response header
+type RobotsDirectives struct {
+	Noindex  bool // The page must not be indexed
+	Nofollow bool // The links of the page must not be followed
+}
+
+// robotsValueDirectives lists the directives that have a value after a colon, so they aren't
+// confused with the user agent prefix of the X-Robots-Tag header
+var robotsValueDirectives = []string{
+	"unavailable_after",
+	"max-snippet",
+	"max-image-preview",
+	"max-video-preview",
+}
+
+// ParseRobotsDirectives reads a comma separated list of directives, like "noindex, nofollow".
+// The "none" directive is the same as both. Other directives are ignored
+func ParseRobotsDirectives(content string) RobotsDirectives {
+	var directives RobotsDirectives
+	directives.add(content)
+	return directives
+}
+
+// ParseXRobotsTag reads the values of the X-Robots-Tag header, keeping the directives that
+// apply to the user agent token (case insensitive). Values prefixed by a user agent, like
+// "otherbot: noindex", only apply to that user agent
+func ParseXRobotsTag(values []string, userAgent string) RobotsDirectives {
+	var directives RobotsDirectives
+
+	for _, value := range values {
+		if index := strings.Index(value, ":"); index >= 0 {
+			prefix := strings.ToLower(strings.TrimSpace(value[:index]))
+
+			agent := !strings.Contains(prefix, ",")
+			for _, valueDirective := range robotsValueDirectives {
+				if prefix == valueDirective {
+					agent = false
+					break
+				}
+			}
+
+			if agent {
+				if prefix != strings.ToLower(strings.TrimSpace(userAgent)) {
+					continue
+				}
+				value = value[index+1:]
+			}
+		}
+
+		directives.add(value)
+	}
+
+	return directives
+}
+
+// add stores the directives of a comma separated list
+func (d *RobotsDirectives) add(content string) {
+	for _, directive := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "noindex":
+			d.Noindex = true
+		case "nofollow":
+			d.Nofollow = true
+		case "none":
+			d.Noindex = true
+			d.Nofollow = true
+		}
+	}
+}
+
+// findMetaRobots travels the HTML document looking for the robots <meta> elements, that apply
+// to all crawlers, and the ones named after the user agent token. The directives of all
+// elements found are combined
+func findMetaRobots(node *html.Node, userAgent string) RobotsDirectives {
+	var directives RobotsDirectives
+	userAgent = strings.TrimSpace(userAgent)
+
+	var find func(*html.Node)
+	find = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "meta" {
+			name, _ := attribute(node, "name")
+			name = strings.TrimSpace(name)
+
+			if strings.EqualFold(name, "robots") ||
+				(len(userAgent) > 0 && strings.EqualFold(name, userAgent)) {
+				content, _ := attribute(node, "content")
+				directives.add(content)
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			find(child)
+		}
+	}
+
+	find(node)
+	return directives
+}
@@ -0,0 +1,215 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	content := `# Comments are ignored
+Sitemap: http://example.com/sitemap.xml
+
+User-agent: *
+Disallow: /tmp/
+Disallow:
+
+User-agent: Crawler
+Sitemap: http://example.com/news.xml.gz
+User-agent: other
+Disallow: /private/ # inline comment
+Allow: /private/public.html
+Crawl-delay: 1.5
+
+User-agent: crawler
+Disallow: /*.pdf$
+`
+
+	testData := []struct {
+		userAgent string
+		expected  Robots
+	}{
+		{
+			userAgent: "crawler",
+			expected: Robots{
+				Rules: []RobotsRule{
+					{Allow: false, Pattern: "/private/"},
+					{Allow: true, Pattern: "/private/public.html"},
+					{Allow: false, Pattern: "/*.pdf$"},
+				},
+				CrawlDelay: 1500 * time.Millisecond,
+				Sitemaps: []string{
+					"http://example.com/sitemap.xml",
+					"http://example.com/news.xml.gz",
+				},
+			},
+		},
+		{
+			userAgent: "unknown",
+			expected: Robots{
+				Rules: []RobotsRule{
+					{Allow: false, Pattern: "/tmp/"},
+				},
+				Sitemaps: []string{
+					"http://example.com/sitemap.xml",
+					"http://example.com/news.xml.gz",
+				},
+			},
+		},
+		{
+			userAgent: "",
+			expected: Robots{
+				Rules: []RobotsRule{
+					{Allow: false, Pattern: "/tmp/"},
+				},
+				Sitemaps: []string{
+					"http://example.com/sitemap.xml",
+					"http://example.com/news.xml.gz",
+				},
+			},
+		},
+	}
+
+	for _, testItem := range testData {
+		robots, err := ParseRobots(strings.NewReader(content), testItem.userAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(*robots, testItem.expected) {
+			t.Errorf("Unexpected rules for user agent '%s'. Expected '%+v' and got '%+v'",
+				testItem.userAgent, testItem.expected, *robots)
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	robots := &Robots{
+		Rules: []RobotsRule{
+			{Allow: false, Pattern: "/"},
+			{Allow: true, Pattern: "/docs/"},
+			{Allow: false, Pattern: "/docs/*.pdf$"},
+			{Allow: false, Pattern: "/docs/draft"},
+			{Allow: false, Pattern: "/shop"},
+			{Allow: true, Pattern: "/shop"},
+			{Allow: true, Pattern: "/search?q=*"},
+			{Allow: false, Pattern: "/search?q=*&page="},
+		},
+	}
+
+	testData := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "/", expected: false},
+		{path: "/about.html", expected: false},
+		{path: "/robots.txt", expected: true},
+		{path: "/docs/", expected: true},
+		{path: "/docs/index.html", expected: true},
+		{path: "/docs/manual.pdf", expected: false},
+		{path: "/docs/manual.pdf?download=1", expected: true},
+		{path: "/docs/drafts.html", expected: false},
+		{path: "/shop/cart.html", expected: true},
+		{path: "/search?q=go", expected: true},
+		{path: "/search?q=go&page=2", expected: false},
+	}
+
+	for _, testItem := range testData {
+		if allowed := robots.Allowed(testItem.path); allowed != testItem.expected {
+			t.Errorf("Unexpected result for path '%s'. Expected '%t' and got '%t'",
+				testItem.path, testItem.expected, allowed)
+		}
+	}
+
+	// Without rules everything is allowed
+	var nilRobots *Robots
+	if !nilRobots.Allowed("/private/") {
+		t.Error("Path disallowed without robots rules")
+	}
+}
+
+func TestMatchRobotsPattern(t *testing.T) {
+	testData := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{pattern: "/", path: "/anything", expected: true},
+		{pattern: "/fish", path: "/fish.html", expected: true},
+		{pattern: "/fish", path: "/Fish.html", expected: false},
+		{pattern: "/fish$", path: "/fish", expected: true},
+		{pattern: "/fish$", path: "/fish/", expected: false},
+		{pattern: "/*.php", path: "/folder/filename.php?parameters", expected: true},
+		{pattern: "/*.php$", path: "/filename.php?parameters", expected: false},
+		{pattern: "/fish*.php", path: "/fishheads/catfish.php?parameters", expected: true},
+		{pattern: "/fish*.php", path: "/Fish.PHP", expected: false},
+		{pattern: "/*a*b$", path: "/xaxbxb", expected: true},
+		{pattern: "*", path: "/", expected: true},
+	}
+
+	for _, testItem := range testData {
+		if match := matchRobotsPattern(testItem.pattern, testItem.path); match != testItem.expected {
+			t.Errorf("Unexpected match of pattern '%s' and path '%s'. Expected '%t' and got '%t'",
+				testItem.pattern, testItem.path, testItem.expected, match)
+		}
+	}
+}
+
+func TestParseRobotsDirectives(t *testing.T) {
+	testData := []struct {
+		content  string
+		expected RobotsDirectives
+	}{
+		{content: "", expected: RobotsDirectives{}},
+		{content: "index, follow", expected: RobotsDirectives{}},
+		{content: "noindex", expected: RobotsDirectives{Noindex: true}},
+		{content: "NOFOLLOW", expected: RobotsDirectives{Nofollow: true}},
+		{content: " noindex ,nofollow ", expected: RobotsDirectives{Noindex: true, Nofollow: true}},
+		{content: "none", expected: RobotsDirectives{Noindex: true, Nofollow: true}},
+		{content: "noarchive, max-snippet:20", expected: RobotsDirectives{}},
+	}
+
+	for _, testItem := range testData {
+		if directives := ParseRobotsDirectives(testItem.content); directives != testItem.expected {
+			t.Errorf("Unexpected directives for '%s'. Expected '%+v' and got '%+v'",
+				testItem.content, testItem.expected, directives)
+		}
+	}
+}
+
+func TestParseXRobotsTag(t *testing.T) {
+	testData := []struct {
+		values   []string
+		expected RobotsDirectives
+	}{
+		{values: nil, expected: RobotsDirectives{}},
+		{values: []string{"noindex"}, expected: RobotsDirectives{Noindex: true}},
+		{
+			values:   []string{"noindex", "nofollow"},
+			expected: RobotsDirectives{Noindex: true, Nofollow: true},
+		},
+		{values: []string{"otherbot: noindex"}, expected: RobotsDirectives{}},
+		{values: []string{"Crawler: nofollow"}, expected: RobotsDirectives{Nofollow: true}},
+		{
+			values:   []string{"unavailable_after: 25 Jun 2010 15:00:00 PST", "nofollow"},
+			expected: RobotsDirectives{Nofollow: true},
+		},
+		{
+			values:   []string{"noindex, unavailable_after: 25 Jun 2010 15:00:00 PST"},
+			expected: RobotsDirectives{Noindex: true},
+		},
+	}
+
+	for _, testItem := range testData {
+		directives := ParseXRobotsTag(testItem.values, DefaultRobotsUserAgent)
+		if directives != testItem.expected {
+			t.Errorf("Unexpected directives for %q. Expected '%+v' and got '%+v'",
+				testItem.values, testItem.expected, directives)
+		}
+	}
+}
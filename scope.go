@@ -0,0 +1,158 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"code.google.com/p/go.net/publicsuffix"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrInvalidURL is returned when the start URL can't be used to define the scope of the
+	// crawl. Only absolute HTTP and HTTPS URLs are accepted
+	ErrInvalidURL = errors.New("invalid URL, expecting an absolute HTTP or HTTPS address")
+)
+
+// Scope defines which URLs belong to the crawled site, and therefore are followed by the
+// crawler. All URLs are compared using their parsed components, so a host like
+// "example.com.other.net" is never confused with "example.com"
+type Scope struct {
+	Scheme string // Scheme of the start URL in lower case
+	Host   string // Host name of the start URL in lower case, without the port
+	Port   string // Port of the start URL, empty when it's the default port of the scheme
+
+	SchemeEquivalence bool     // Flag to treat HTTP and HTTPS addresses as the same site
+	WWWEquivalence    bool     // Flag to treat "www.example.com" and "example.com" as the same host
+	Subdomains        []string // Subdomains of the registrable domain also followed, "*" allows all
+	PathPrefix        string   // When defined, only paths starting with this prefix are followed
+}
+
+// NewScope make it easy to initialize a scope from the start URL of the crawl. By default
+// HTTP and HTTPS are equivalent, the "www." prefix is ignored, subdomains aren't followed
+// and there's no path restriction
+func NewScope(rawURL string) (*Scope, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "http" && scheme != "https") || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+
+	return &Scope{
+		Scheme:            scheme,
+		Host:              strings.ToLower(u.Hostname()),
+		Port:              effectivePort(scheme, u.Port()),
+		SchemeEquivalence: true,
+		WWWEquivalence:    true,
+	}, nil
+}
+
+// Contains verify if the URL belongs to the site, and should be followed
+func (s Scope) Contains(rawURL string) bool {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != s.Scheme {
+		if !s.SchemeEquivalence || (scheme != "http" && scheme != "https") {
+			return false
+		}
+	}
+
+	if effectivePort(scheme, u.Port()) != s.Port {
+		return false
+	}
+
+	if !s.containsHost(strings.ToLower(u.Hostname())) {
+		return false
+	}
+
+	if len(s.PathPrefix) > 0 {
+		path := u.EscapedPath()
+		if len(path) == 0 {
+			path = "/"
+		}
+
+		if !strings.HasPrefix(path, s.PathPrefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsHost compares the host with the scope host, and if they are different checks if the
+// host is an allowed subdomain of the registrable domain
+func (s Scope) containsHost(host string) bool {
+	if len(host) == 0 {
+		return false
+	}
+
+	scopeHost := s.Host
+	if s.WWWEquivalence {
+		host = strings.TrimPrefix(host, "www.")
+		scopeHost = strings.TrimPrefix(scopeHost, "www.")
+	}
+
+	if host == scopeHost {
+		return true
+	}
+
+	if len(s.Subdomains) == 0 {
+		return false
+	}
+
+	domain := registrableDomain(s.Host)
+
+	subdomain := ""
+	if host != domain {
+		if !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+		subdomain = strings.TrimSuffix(host, "."+domain)
+	}
+
+	for _, allowed := range s.Subdomains {
+		if allowed == "*" || (len(subdomain) > 0 && strings.EqualFold(allowed, subdomain)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registrableDomain returns the part of the host that can be registered by a person or an
+// organization (e.g. "example.co.uk" for "www.example.co.uk"), based on the public suffix
+// list. IP addresses and hosts without a public suffix are returned untouched
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+
+	return domain
+}
+
+// effectivePort returns an empty port when it's the default port of the scheme, so that
+// "http://example.com:80" and "http://example.com" are considered the same address
+func effectivePort(scheme, port string) string {
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		return ""
+	}
+
+	return port
+}
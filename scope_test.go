@@ -0,0 +1,247 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"testing"
+)
+
+func TestNewScope(t *testing.T) {
+	testData := []struct {
+		url      string
+		expected *Scope
+	}{
+		{
+			url: "http://Example.com",
+			expected: &Scope{
+				Scheme:            "http",
+				Host:              "example.com",
+				SchemeEquivalence: true,
+				WWWEquivalence:    true,
+			},
+		},
+		{
+			url: "https://www.example.com:443/docs/index.html",
+			expected: &Scope{
+				Scheme:            "https",
+				Host:              "www.example.com",
+				SchemeEquivalence: true,
+				WWWEquivalence:    true,
+			},
+		},
+		{
+			url: "http://127.0.0.1:8080",
+			expected: &Scope{
+				Scheme:            "http",
+				Host:              "127.0.0.1",
+				Port:              "8080",
+				SchemeEquivalence: true,
+				WWWEquivalence:    true,
+			},
+		},
+		{url: "example.com", expected: nil},
+		{url: "ftp://example.com", expected: nil},
+		{url: "http://", expected: nil},
+		{url: "%zz", expected: nil},
+	}
+
+	for _, testItem := range testData {
+		scope, err := NewScope(testItem.url)
+
+		if testItem.expected == nil {
+			if err != ErrInvalidURL {
+				t.Errorf("Unexpected error for '%s'. Expected '%v' and got '%v'",
+					testItem.url, ErrInvalidURL, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for '%s'. Expected '%v' and got '%v'", testItem.url, nil, err)
+			continue
+		}
+
+		if scope.Scheme != testItem.expected.Scheme ||
+			scope.Host != testItem.expected.Host ||
+			scope.Port != testItem.expected.Port ||
+			scope.SchemeEquivalence != testItem.expected.SchemeEquivalence ||
+			scope.WWWEquivalence != testItem.expected.WWWEquivalence ||
+			len(scope.Subdomains) > 0 ||
+			len(scope.PathPrefix) > 0 {
+
+			t.Errorf("Unexpected scope for '%s'. Expected '%+v' and got '%+v'",
+				testItem.url, testItem.expected, scope)
+		}
+	}
+}
+
+func TestScopeContains(t *testing.T) {
+	testData := []struct {
+		description string
+		startURL    string
+		configure   func(*Scope)
+		url         string
+		expected    bool
+	}{
+		{
+			description: "same host",
+			startURL:    "http://example.com",
+			url:         "http://example.com/page.html",
+			expected:    true,
+		},
+		{
+			description: "host in upper case",
+			startURL:    "http://example.com",
+			url:         "http://EXAMPLE.COM/page.html",
+			expected:    true,
+		},
+		{
+			description: "look-alike host",
+			startURL:    "http://example.com",
+			url:         "http://example.com.evil.net/page.html",
+			expected:    false,
+		},
+		{
+			description: "external host",
+			startURL:    "http://example.com",
+			url:         "http://example.net",
+			expected:    false,
+		},
+		{
+			description: "HTTPS with scheme equivalence",
+			startURL:    "http://example.com",
+			url:         "https://example.com/secure.html",
+			expected:    true,
+		},
+		{
+			description: "HTTPS without scheme equivalence",
+			startURL:    "http://example.com",
+			configure:   func(s *Scope) { s.SchemeEquivalence = false },
+			url:         "https://example.com/secure.html",
+			expected:    false,
+		},
+		{
+			description: "non HTTP scheme",
+			startURL:    "http://example.com",
+			url:         "mailto:someone@example.com",
+			expected:    false,
+		},
+		{
+			description: "explicit default port",
+			startURL:    "http://example.com",
+			url:         "http://example.com:80/page.html",
+			expected:    true,
+		},
+		{
+			description: "different port",
+			startURL:    "http://example.com",
+			url:         "http://example.com:8080/page.html",
+			expected:    false,
+		},
+		{
+			description: "www prefix with www equivalence",
+			startURL:    "http://example.com",
+			url:         "http://www.example.com/page.html",
+			expected:    true,
+		},
+		{
+			description: "www prefix without www equivalence",
+			startURL:    "http://example.com",
+			configure:   func(s *Scope) { s.WWWEquivalence = false },
+			url:         "http://www.example.com/page.html",
+			expected:    false,
+		},
+		{
+			description: "subdomain not allowed",
+			startURL:    "http://example.com",
+			url:         "http://blog.example.com/page.html",
+			expected:    false,
+		},
+		{
+			description: "allowed subdomain",
+			startURL:    "http://example.com",
+			configure:   func(s *Scope) { s.Subdomains = []string{"blog"} },
+			url:         "http://blog.example.com/page.html",
+			expected:    true,
+		},
+		{
+			description: "other subdomain",
+			startURL:    "http://example.com",
+			configure:   func(s *Scope) { s.Subdomains = []string{"blog"} },
+			url:         "http://shop.example.com/page.html",
+			expected:    false,
+		},
+		{
+			description: "any subdomain",
+			startURL:    "http://www.example.co.uk",
+			configure:   func(s *Scope) { s.Subdomains = []string{"*"} },
+			url:         "http://a.b.example.co.uk/page.html",
+			expected:    true,
+		},
+		{
+			description: "registrable domain with any subdomain",
+			startURL:    "http://blog.example.co.uk",
+			configure:   func(s *Scope) { s.Subdomains = []string{"*"} },
+			url:         "http://example.co.uk/page.html",
+			expected:    true,
+		},
+		{
+			description: "public suffix with any subdomain",
+			startURL:    "http://www.example.co.uk",
+			configure:   func(s *Scope) { s.Subdomains = []string{"*"} },
+			url:         "http://other.co.uk/page.html",
+			expected:    false,
+		},
+		{
+			description: "start URL path doesn't restrict the crawl",
+			startURL:    "http://example.com/docs/",
+			url:         "http://example.com/blog/",
+			expected:    true,
+		},
+		{
+			description: "path prefix",
+			startURL:    "http://example.com/docs/",
+			configure:   func(s *Scope) { s.PathPrefix = "/docs/" },
+			url:         "http://example.com/docs/page.html",
+			expected:    true,
+		},
+		{
+			description: "outside path prefix",
+			startURL:    "http://example.com/docs/",
+			configure:   func(s *Scope) { s.PathPrefix = "/docs/" },
+			url:         "http://example.com/blog/",
+			expected:    false,
+		},
+		{
+			description: "IP address",
+			startURL:    "http://127.0.0.1:8080",
+			configure:   func(s *Scope) { s.Subdomains = []string{"*"} },
+			url:         "http://127.0.0.1:8080/page.html",
+			expected:    true,
+		},
+		{
+			description: "relative URL",
+			startURL:    "http://example.com",
+			url:         "page.html",
+			expected:    false,
+		},
+	}
+
+	for _, testItem := range testData {
+		scope, err := NewScope(testItem.startURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if testItem.configure != nil {
+			testItem.configure(scope)
+		}
+
+		if contains := scope.Contains(testItem.url); contains != testItem.expected {
+			t.Errorf("Unexpected scope result on %s test. Expected %v and got %v",
+				testItem.description, testItem.expected, contains)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxSitemapURLs is the maximum number of URLs in a sitemap file, as defined by the
+	// sitemaps.org protocol. Bigger sites must be split in many files referenced by a sitemap
+	// index
+	MaxSitemapURLs = 50000
+
+	// MaxSitemapSize is the maximum size in bytes of an uncompressed sitemap file, as defined by
+	// the sitemaps.org protocol
+	MaxSitemapSize = 50 * 1024 * 1024
+
+	// sitemapNamespace is the XML namespace of the sitemaps.org protocol
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+var (
+	// ErrSitemapTooLarge is returned when trying to write more than MaxSitemapURLs URLs in a
+	// single sitemap file
+	ErrSitemapTooLarge = errors.New("too many URLs for a single sitemap file")
+
+	// ErrInvalidSitemap is returned when reading a file that isn't a sitemap or a sitemap index
+	ErrInvalidSitemap = errors.New("invalid sitemap file")
+)
+
+// SitemapURL is an entry of a sitemap or of a sitemap index
+type SitemapURL struct {
+	Loc     string `xml:"loc"`               // Address of the page or of the sitemap file
+	LastMod string `xml:"lastmod,omitempty"` // Date of last modification in W3C Datetime format
+}
+
+// sitemapURLSet is the root element of a sitemap file
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []SitemapURL `xml:"url"`
+}
+
+// sitemapIndex is the root element of a sitemap index file
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []SitemapURL `xml:"sitemap"`
+}
+
+// ParseSitemap reads a sitemap or a sitemap index in the sitemaps.org XML format, returning
+// the entries of the pages or of the sitemap files respectively. Content compressed with gzip
+// is detected and decompressed. Files bigger than MaxSitemapSize return ErrBodyTooLarge
+func ParseSitemap(r io.Reader) ([]SitemapURL, []SitemapURL, error) {
+	reader := bufio.NewReader(r)
+
+	// The compressed files are identified by the content, as the servers don't always inform
+	// the encoding correctly
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gzipReader.Close()
+
+		reader = bufio.NewReader(gzipReader)
+	}
+
+	decoder := xml.NewDecoder(newBodyReader(reader, MaxSitemapSize))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, nil, ErrInvalidSitemap
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "urlset":
+			var urlSet sitemapURLSet
+			if err := decoder.DecodeElement(&urlSet, &start); err != nil {
+				return nil, nil, err
+			}
+			return trimSitemapURLs(urlSet.URLs), nil, nil
+
+		case "sitemapindex":
+			var index sitemapIndex
+			if err := decoder.DecodeElement(&index, &start); err != nil {
+				return nil, nil, err
+			}
+			return nil, trimSitemapURLs(index.Sitemaps), nil
+		}
+
+		return nil, nil, ErrInvalidSitemap
+	}
+}
+
+// trimSitemapURLs removes the spaces around the addresses, ignoring the entries without
+// address
+func trimSitemapURLs(entries []SitemapURL) []SitemapURL {
+	var trimmed []SitemapURL
+	for _, entry := range entries {
+		entry.Loc = strings.TrimSpace(entry.Loc)
+		if len(entry.Loc) > 0 {
+			trimmed = append(trimmed, entry)
+		}
+	}
+	return trimmed
+}
+
+// SitemapURLs travels the page tree collecting the pages that should be listed in a sitemap.
+// Failed, skipped, external and noindex pages are excluded. When the page was redirected the
+// final URL is used, and pages redirected outside the scope are also excluded (a nil scope
+// accepts any final URL). The last modification date is retrieved from the Last-Modified
+// header (see CrawlOptions.Headers)
+func SitemapURLs(page *Page, scope *Scope) []SitemapURL {
+	var urls []SitemapURL
+	found := make(map[string]bool)
+
+	walkPages(page, func(p *Page) {
+		loc, ok := sitemapLoc(p, scope)
+		if !ok || found[loc] {
+			return
+		}
+		found[loc] = true
+
+		sitemapURL := SitemapURL{
+			Loc: loc,
+		}
+
+		if lastModified, err := http.ParseTime(p.Header.Get("Last-Modified")); err == nil {
+			sitemapURL.LastMod = lastModified.UTC().Format(time.RFC3339)
+		}
+
+		urls = append(urls, sitemapURL)
+	})
+
+	return urls
+}
+
+// sitemapLoc returns the address of the page in a sitemap, that is the final URL when the page
+// was redirected. The page can only be listed when it was crawled, is indexable and its final
+// URL is in the scope
+func sitemapLoc(page *Page, scope *Scope) (string, bool) {
+	if page.External || page.Fail || len(page.Skipped) > 0 || page.Noindex {
+		return "", false
+	}
+
+	if len(page.FinalURL) == 0 {
+		return page.URL, true
+	}
+
+	if scope != nil && !scope.Contains(page.FinalURL) {
+		return "", false
+	}
+
+	return page.FinalURL, true
+}
+
+// WriteSitemap writes the URLs in the sitemaps.org XML format. If there are more URLs than
+// the protocol allows in a single file ErrSitemapTooLarge is returned
+func WriteSitemap(w io.Writer, urls []SitemapURL) error {
+	if len(urls) > MaxSitemapURLs {
+		return ErrSitemapTooLarge
+	}
+
+	return writeXML(w, sitemapURLSet{
+		Xmlns: sitemapNamespace,
+		URLs:  urls,
+	})
+}
+
+// WriteSitemapIndex writes a sitemap index in the sitemaps.org XML format, where each entry
+// is the address of a sitemap file
+func WriteSitemapIndex(w io.Writer, sitemaps []SitemapURL) error {
+	if len(sitemaps) > MaxSitemapURLs {
+		return ErrSitemapTooLarge
+	}
+
+	return writeXML(w, sitemapIndex{
+		Xmlns:    sitemapNamespace,
+		Sitemaps: sitemaps,
+	})
+}
+
+// writeXML writes the XML header and the indented content
+func writeXML(w io.Writer, content interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(content); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ExportSitemaps writes the sitemap of the page tree in the directory. When the site has more
+// than MaxSitemapURLs pages, the URLs are split in many sitemap files (sitemap1.xml,
+// sitemap2.xml, ...) and a sitemap index (sitemap.xml) is created referencing them, using
+// baseURL as the address of the directory. Compressed files have the ".gz" extension. The
+// paths of all written files are returned. The listed pages are the ones of SitemapURLs
+func ExportSitemaps(dir, baseURL string, page *Page, scope *Scope,
+	compress bool) ([]string, error) {
+	urls := SitemapURLs(page, scope)
+
+	extension := ".xml"
+	if compress {
+		extension += ".gz"
+	}
+
+	if len(urls) <= MaxSitemapURLs {
+		filename := filepath.Join(dir, "sitemap"+extension)
+		err := writeSitemapFile(filename, compress, func(w io.Writer) error {
+			return WriteSitemap(w, urls)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{filename}, nil
+	}
+
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	var filenames []string
+	var sitemaps []SitemapURL
+
+	for i := 0; i*MaxSitemapURLs < len(urls); i++ {
+		end := (i + 1) * MaxSitemapURLs
+		if end > len(urls) {
+			end = len(urls)
+		}
+		part := urls[i*MaxSitemapURLs : end]
+
+		name := fmt.Sprintf("sitemap%d%s", i+1, extension)
+		filename := filepath.Join(dir, name)
+
+		err := writeSitemapFile(filename, compress, func(w io.Writer) error {
+			return WriteSitemap(w, part)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		filenames = append(filenames, filename)
+		sitemaps = append(sitemaps, SitemapURL{
+			Loc:     baseURL + name,
+			LastMod: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+
+	filename := filepath.Join(dir, "sitemap"+extension)
+	err := writeSitemapFile(filename, compress, func(w io.Writer) error {
+		return WriteSitemapIndex(w, sitemaps)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{filename}, filenames...), nil
+}
+
+// writeSitemapFile creates the file, optionally compressing the content with gzip
+func writeSitemapFile(filename string, compress bool, write func(io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !compress {
+		if err := write(file); err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	gzipWriter := gzip.NewWriter(file)
+	if err := write(gzipWriter); err != nil {
+		return err
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
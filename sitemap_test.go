@@ -0,0 +1,288 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSitemapURLs(t *testing.T) {
+	page := &Page{
+		URL:      "http://example.com",
+		FinalURL: "http://example.com/",
+		Header: http.Header{
+			"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+		},
+	}
+
+	page.Links = []Link{
+		{Label: "About", Page: &Page{URL: "http://example.com/about.html"}},
+		{Label: "Old", Page: &Page{URL: "http://example.com/old.html",
+			FinalURL: "http://example.com/about.html"}},
+		{Label: "Missing", Page: &Page{URL: "http://example.com/missing.html", Fail: true}},
+		{Label: "Deep", Page: &Page{URL: "http://example.com/deep.html", Skipped: SkipMaxDepth}},
+		{Label: "External", Page: &Page{URL: "http://example.net", External: true}},
+		{Label: "Private", Page: &Page{URL: "http://example.com/private.html", Noindex: true}},
+		{Label: "Moved", Page: &Page{URL: "http://example.com/moved.html",
+			FinalURL: "http://example.net/moved.html"}},
+		{Label: "Home", Page: page, CyclicPage: true},
+		{Label: "Anchor"},
+	}
+
+	scope, err := NewScope("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SitemapURL{
+		{Loc: "http://example.com/", LastMod: "2015-10-21T07:28:00Z"},
+		{Loc: "http://example.com/about.html"},
+	}
+
+	if urls := SitemapURLs(page, scope); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Unexpected sitemap URLs. Expected '%v' and got '%v'", expected, urls)
+	}
+
+	// Without a scope the final URLs aren't verified
+	expected = append(expected, SitemapURL{Loc: "http://example.net/moved.html"})
+	if urls := SitemapURLs(page, nil); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Unexpected sitemap URLs without scope. Expected '%v' and got '%v'",
+			expected, urls)
+	}
+}
+
+func TestWriteSitemap(t *testing.T) {
+	urls := []SitemapURL{
+		{Loc: "http://example.com/", LastMod: "2015-10-21T07:28:00Z"},
+		{Loc: "http://example.com/search?q=a&b=c"},
+	}
+
+	var output bytes.Buffer
+	if err := WriteSitemap(&output, urls); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>http://example.com/</loc>
+    <lastmod>2015-10-21T07:28:00Z</lastmod>
+  </url>
+  <url>
+    <loc>http://example.com/search?q=a&amp;b=c</loc>
+  </url>
+</urlset>
+`
+
+	if output.String() != expected {
+		t.Errorf("Unexpected sitemap. Expected %s and got %s", expected, output.String())
+	}
+
+	if err := WriteSitemap(&output, make([]SitemapURL, MaxSitemapURLs+1)); err != ErrSitemapTooLarge {
+		t.Errorf("Unexpected error. Expected '%v' and got '%v'", ErrSitemapTooLarge, err)
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlSet := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc> http://example.com/ </loc>
+    <lastmod>2015-10-21T07:28:00Z</lastmod>
+  </url>
+  <url><loc>http://example.com/search?q=a&amp;b=c</loc></url>
+  <url><loc></loc></url>
+</urlset>`
+
+	index := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://example.com/sitemap1.xml.gz</loc></sitemap>
+</sitemapindex>`
+
+	testData := []struct {
+		content          string
+		expectedURLs     []SitemapURL
+		expectedSitemaps []SitemapURL
+		expectedErr      error
+	}{
+		{
+			content: urlSet,
+			expectedURLs: []SitemapURL{
+				{Loc: "http://example.com/", LastMod: "2015-10-21T07:28:00Z"},
+				{Loc: "http://example.com/search?q=a&b=c"},
+			},
+		},
+		{
+			content: gzipContent(t, urlSet),
+			expectedURLs: []SitemapURL{
+				{Loc: "http://example.com/", LastMod: "2015-10-21T07:28:00Z"},
+				{Loc: "http://example.com/search?q=a&b=c"},
+			},
+		},
+		{
+			content: index,
+			expectedSitemaps: []SitemapURL{
+				{Loc: "http://example.com/sitemap1.xml.gz"},
+			},
+		},
+		{
+			content:     `<?xml version="1.0"?><rss version="2.0"></rss>`,
+			expectedErr: ErrInvalidSitemap,
+		},
+		{
+			content:     "",
+			expectedErr: ErrInvalidSitemap,
+		},
+	}
+
+	for i, testItem := range testData {
+		urls, sitemaps, err := ParseSitemap(strings.NewReader(testItem.content))
+		if err != testItem.expectedErr {
+			t.Errorf("Unexpected error in item %d. Expected '%v' and got '%v'",
+				i, testItem.expectedErr, err)
+		}
+
+		if !reflect.DeepEqual(urls, testItem.expectedURLs) {
+			t.Errorf("Unexpected URLs in item %d. Expected '%v' and got '%v'",
+				i, testItem.expectedURLs, urls)
+		}
+
+		if !reflect.DeepEqual(sitemaps, testItem.expectedSitemaps) {
+			t.Errorf("Unexpected sitemaps in item %d. Expected '%v' and got '%v'",
+				i, testItem.expectedSitemaps, sitemaps)
+		}
+	}
+}
+
+// gzipContent compresses the content with gzip
+func gzipContent(t *testing.T, content string) string {
+	var output bytes.Buffer
+	writer := gzip.NewWriter(&output)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.String()
+}
+
+func TestExportSitemaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Small site test
+	page := &Page{
+		URL: "http://example.com/",
+		Links: []Link{
+			{Label: "About", Page: &Page{URL: "http://example.com/about.html"}},
+		},
+	}
+
+	filenames, err := ExportSitemaps(dir, "http://example.com", page, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(filenames, []string{filepath.Join(dir, "sitemap.xml")}) {
+		t.Errorf("Unexpected sitemap files: %v", filenames)
+	}
+
+	var urlSet sitemapURLSet
+	readSitemapFile(t, filenames[0], false, &urlSet)
+	if len(urlSet.URLs) != 2 {
+		t.Errorf("Unexpected number of URLs in the sitemap. Expected 2 and got %d", len(urlSet.URLs))
+	}
+
+	// Big site test
+	page = &Page{URL: "http://example.com/"}
+	for i := 0; i < MaxSitemapURLs+10; i++ {
+		page.Links = append(page.Links, Link{
+			Label: "Page",
+			Page:  &Page{URL: fmt.Sprintf("http://example.com/page%d.html", i)},
+		})
+	}
+
+	filenames, err = ExportSitemaps(dir, "http://example.com", page, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFilenames := []string{
+		filepath.Join(dir, "sitemap.xml.gz"),
+		filepath.Join(dir, "sitemap1.xml.gz"),
+		filepath.Join(dir, "sitemap2.xml.gz"),
+	}
+
+	if !reflect.DeepEqual(filenames, expectedFilenames) {
+		t.Fatalf("Unexpected sitemap files. Expected %v and got %v", expectedFilenames, filenames)
+	}
+
+	var index sitemapIndex
+	readSitemapFile(t, filenames[0], true, &index)
+	if len(index.Sitemaps) != 2 ||
+		index.Sitemaps[0].Loc != "http://example.com/sitemap1.xml.gz" ||
+		index.Sitemaps[1].Loc != "http://example.com/sitemap2.xml.gz" {
+		t.Errorf("Unexpected sitemap index: %v", index.Sitemaps)
+	}
+
+	urlSet = sitemapURLSet{}
+	readSitemapFile(t, filenames[1], true, &urlSet)
+	if len(urlSet.URLs) != MaxSitemapURLs {
+		t.Errorf("Unexpected number of URLs in the first sitemap. Expected %d and got %d",
+			MaxSitemapURLs, len(urlSet.URLs))
+	}
+
+	urlSet = sitemapURLSet{}
+	readSitemapFile(t, filenames[2], true, &urlSet)
+	if len(urlSet.URLs) != 11 {
+		t.Errorf("Unexpected number of URLs in the second sitemap. Expected 11 and got %d",
+			len(urlSet.URLs))
+	}
+}
+
+// readSitemapFile decodes the XML content of the file, decompressing it when necessary
+func readSitemapFile(t *testing.T, filename string, compressed bool, content interface{}) {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compressed {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data, err = ioutil.ReadAll(reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := xml.Unmarshal(data, content); err != nil {
+		t.Fatal(err)
+	}
+}
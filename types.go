@@ -5,22 +5,111 @@
 package crawler
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+)
+
+// SkipReason describes why a page of the site was found but wasn't crawled
+type SkipReason string
+
+// List of possible reasons to skip a page
+const (
+	SkipMaxDepth  SkipReason = "max depth" // The page is too far from the start page
+	SkipMaxPages  SkipReason = "max pages" // The limit of crawled pages was reached
+	SkipCancelled SkipReason = "cancelled" // The crawl was cancelled before fetching the page
+	SkipRobots    SkipReason = "robots"    // The page is disallowed by the robots.txt of the site
+	SkipDuplicate SkipReason = "duplicate" // The page redirects to a page that was already crawled
+	SkipNofollow  SkipReason = "nofollow"  // The link is marked as nofollow by the page
+	SkipCanonical SkipReason = "canonical" // The page has the same canonical URL of a crawled page
+)
+
+var (
+	// ErrRedirectLoop is used when a redirect points to an address already visited in the same
+	// chain of redirects
+	ErrRedirectLoop = errors.New("redirect loop")
 )
 
 // Page describes the information stored after a webpage is crawled
 type Page struct {
-	URL          string   // Address of the page
-	Fail         bool     // Flag to indicate that the system failed to access the URL
-	Links        []Link   // List of links for other URLs in this page
-	StaticAssets []string // List of static dependencies of this page
+	URL           string        // Address of the page
+	FinalURL      string        // Address of the page after following redirects
+	Canonical     string        // Preferred address of the page defined by <link rel="canonical">
+	Depth         int           // Number of links followed from the start page to find this page
+	External      bool          // Flag to indicate that the page is outside the scope of the crawl
+	Fail          bool          // Flag to indicate that the system failed to access the URL
+	Error         string        // Reason of the failure when it isn't an HTTP status code
+	Skipped       SkipReason    // Reason why the page wasn't crawled, empty when it was crawled
+	Document      bool          // Flag to indicate that the content isn't HTML and wasn't analyzed
+	Noindex       bool          // Flag to indicate that the page asks to not be indexed
+	Nofollow      bool          // Flag to indicate that the page asks to not follow its links
+	StatusCode    int           // HTTP status code of the response, zero when unknown
+	ContentType   string        // Media type of the page content
+	ContentLength int64         // Size of the page content in bytes, -1 when unknown
+	Header        http.Header   // Response headers of interest (see CrawlOptions.Headers)
+	Duration      time.Duration // Time to first byte, the body is streamed and isn't measured
+	Attempts      int           // Number of requests sent to retrieve the page, zero when unknown
+	Redirects     []Redirect    // Redirects followed from the URL until the final URL
+	Links         []Link        // List of links for other URLs in this page
+	StaticAssets  []Asset       // List of static dependencies of this page
+
+	// AssetChecks stores the availability of each static asset, indexed by the asset URL. It's
+	// only filled when the resources are checked (see CrawlOptions.CheckResources) or, for the
+	// stylesheets, when they are analyzed (see CrawlOptions.CrawlStylesheets)
+	AssetChecks map[string]*Resource
+
+	// Seeds lists the other start pages of the crawl (see CrawlSeeds) and the pages found in the
+	// sitemaps of the site (see CrawlOptions.UseSitemaps). It's only filled in the first start
+	// page, and the pages that were already reached are marked as cyclic pages
+	Seeds []Link
+}
+
+// setResponse copies the metadata of the response to the page. Only the listed headers are
+// stored. A status code that represents an error (4xx or 5xx) marks the page as failed
+func (p *Page) setResponse(response *Response, headers []string) {
+	p.FinalURL = response.URL
+	p.StatusCode = response.StatusCode
+	p.ContentType = response.ContentType
+	p.ContentLength = response.ContentLength
+	p.Duration = response.Duration
+	p.Attempts = response.Attempts
+	p.Redirects = response.Redirects
+
+	for _, header := range headers {
+		values := response.Header[http.CanonicalHeaderKey(header)]
+		if len(values) == 0 {
+			continue
+		}
+
+		if p.Header == nil {
+			p.Header = make(http.Header)
+		}
+		p.Header[http.CanonicalHeaderKey(header)] = values
+	}
+
+	if p.StatusCode >= 400 {
+		p.Fail = true
+	}
+}
+
+// setError marks the page as failed because of the error. When the error was returned after
+// many attempts (see RetryFetcher) the number of attempts is also stored
+func (p *Page) setError(err error) {
+	p.Fail = true
+	p.Error = err.Error()
+
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		p.Attempts = retryErr.Attempts
+	}
 }
 
 // String transforms the Page into text mode to print the results
@@ -31,13 +120,48 @@ func (p Page) String() string {
 			staticAssets += "\n"
 		}
 
-		staticAssets += fmt.Sprintf(`  ▤  %s`, staticAsset)
+		staticAssets += fmt.Sprintf(`  ▤  %s`, staticAsset.URL)
+		if len(staticAsset.Kind) > 0 {
+			staticAssets += fmt.Sprintf(" [%s]", staticAsset.Kind)
+		}
+	}
+
+	pageStr := ""
+	if p.Fail && p.StatusCode > 0 {
+		pageStr = fmt.Sprintf("\n❆ %s ✗ (%d)\n", p.URL, p.StatusCode)
+	} else if p.Fail {
+		pageStr = fmt.Sprintf("\n❆ %s ✗\n", p.URL)
+	} else if len(p.Skipped) > 0 {
+		pageStr = fmt.Sprintf("\n❆ %s ⊘ (%s)\n", p.URL, p.Skipped)
+	} else {
+		pageStr = fmt.Sprintf("\n❆ %s\n", p.URL)
 	}
 
-	links := ""
-	for _, link := range p.Links {
-		if len(links) > 0 {
-			links += "\n"
+	// Don't add unecessary spaces when there's no information
+	if len(staticAssets) > 0 {
+		pageStr += "\n" + staticAssets + "\n"
+	}
+
+	// Don't add unecessary spaces when there's no information
+	if links := linksString(p.Links); len(links) > 0 {
+		pageStr += "\n" + links + "\n"
+	}
+
+	// Don't add unecessary spaces when there's no information
+	if seeds := linksString(p.Seeds); len(seeds) > 0 {
+		pageStr += "\n" + seeds + "\n"
+	}
+
+	return pageStr
+}
+
+// linksString transforms the links into text mode, printing the content of the target pages
+// that weren't printed yet
+func linksString(links []Link) string {
+	linksStr := ""
+	for _, link := range links {
+		if len(linksStr) > 0 {
+			linksStr += "\n"
 		}
 
 		linkPage := ""
@@ -54,28 +178,11 @@ func (p Page) String() string {
 			}
 		}
 
-		links += fmt.Sprintf(`  ↳ "%s"
+		linksStr += fmt.Sprintf(`  ↳ "%s"
   %s`, link.Label, linkPage)
 	}
 
-	pageStr := ""
-	if p.Fail {
-		pageStr = fmt.Sprintf("\n❆ %s ✗\n", p.URL)
-	} else {
-		pageStr = fmt.Sprintf("\n❆ %s\n", p.URL)
-	}
-
-	// Don't add unecessary spaces when there's no information
-	if len(staticAssets) > 0 {
-		pageStr += "\n" + staticAssets + "\n"
-	}
-
-	// Don't add unecessary spaces when there's no information
-	if len(links) > 0 {
-		pageStr += "\n" + links + "\n"
-	}
-
-	return pageStr
+	return linksStr
 }
 
 // Equal compares a pair os pages to see if they are equal. This method has an special
@@ -83,25 +190,32 @@ func (p Page) String() string {
 // their content, it also does not compare when is a link cyclic page, to avoid infinite
 // recursion
 func (p Page) Equal(other Page) bool {
-	if p.URL != other.URL ||
-		!reflect.DeepEqual(p.StaticAssets, other.StaticAssets) ||
-		len(p.Links) != len(other.Links) {
+	return p.URL == other.URL &&
+		reflect.DeepEqual(p.StaticAssets, other.StaticAssets) &&
+		linksEqual(p.Links, other.Links) &&
+		linksEqual(p.Seeds, other.Seeds)
+}
+
+// linksEqual compares the links and the content of their target pages, except for the cyclic
+// pages (see Page.Equal)
+func linksEqual(links, others []Link) bool {
+	if len(links) != len(others) {
 		return false
 	}
 
-	for i := 0; i < len(p.Links); i++ {
-		if p.Links[i].Label != other.Links[i].Label ||
-			p.Links[i].CyclicPage != other.Links[i].CyclicPage {
+	for i := 0; i < len(links); i++ {
+		if links[i].Label != others[i].Label ||
+			links[i].CyclicPage != others[i].CyclicPage {
 			return false
 		}
 
-		if (p.Links[i].Page == nil && other.Links[i].Page != nil) ||
-			(p.Links[i].Page != nil && other.Links[i].Page == nil) {
+		if (links[i].Page == nil && others[i].Page != nil) ||
+			(links[i].Page != nil && others[i].Page == nil) {
 			return false
 
-		} else if p.Links[i].Page != nil && other.Links[i].Page != nil {
+		} else if links[i].Page != nil && others[i].Page != nil {
 			// Don't check again when the page was already verified
-			if !p.Links[i].CyclicPage && !p.Links[i].Page.Equal(*other.Links[i].Page) {
+			if !links[i].CyclicPage && !links[i].Page.Equal(*others[i].Page) {
 				return false
 			}
 		}
@@ -110,75 +224,700 @@ func (p Page) Equal(other Page) bool {
 	return true
 }
 
+// walkPages travels the page tree calling fn once for each distinct page, including the
+// pages of cyclic links and the pages of the seeds
+func walkPages(page *Page, fn func(*Page)) {
+	walkTree(page, true, fn)
+}
+
+// walkLinkedPages travels the page tree calling fn once for each distinct page reached by
+// links from the start pages, ignoring the pages that were only found in the sitemaps
+func walkLinkedPages(page *Page, fn func(*Page)) {
+	walkTree(page, false, fn)
+}
+
+// walkTree travels the page tree calling fn once for each distinct page, starting from the
+// page and from the other start pages of its seeds. The seeds found in the sitemaps are only
+// followed when the sitemaps flag is true
+func walkTree(page *Page, sitemaps bool, fn func(*Page)) {
+	visited := make(map[*Page]bool)
+
+	var walk func(*Page)
+	walk = func(page *Page) {
+		if page == nil || visited[page] {
+			return
+		}
+
+		visited[page] = true
+		fn(page)
+
+		for _, link := range page.Links {
+			walk(link.Page)
+		}
+	}
+
+	walk(page)
+
+	if page != nil {
+		for _, seed := range page.Seeds {
+			if sitemaps || !seed.Sitemap {
+				walk(seed.Page)
+			}
+		}
+	}
+}
+
+// distinctPages returns one page for each distinct page URL of the tree, in the order that the
+// URLs are found. The URLs are compared using the normalizer, that can be nil, so "/b" and
+// "/b#top" are the same page with the default normalizer. Links that weren't followed, like the
+// nofollow ones, have their own page objects with the same URL of the crawled page, so the
+// crawled page is preferred when it exists
+func distinctPages(page *Page, normalizer Normalizer) []*Page {
+	var pages []*Page
+	positions := make(map[string]int)
+
+	walkPages(page, func(p *Page) {
+		key := normalizeURL(normalizer, p.URL)
+
+		position, found := positions[key]
+		if !found {
+			positions[key] = len(pages)
+			pages = append(pages, p)
+		} else if len(pages[position].Skipped) > 0 && len(p.Skipped) == 0 {
+			pages[position] = p
+		}
+	})
+
+	return pages
+}
+
+// walkResources travels the resources referenced by the page tree calling fn once for each
+// distinct resource, including the dependencies of the analyzed stylesheets
+func walkResources(page *Page, fn func(*Resource)) {
+	visited := make(map[*Resource]bool)
+
+	var walk func(map[string]*Resource)
+	walk = func(resources map[string]*Resource) {
+		// The order of the resources must not depend on the map iteration
+		var urls []string
+		for url := range resources {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+
+		for _, url := range urls {
+			resource := resources[url]
+			if resource == nil || visited[resource] {
+				continue
+			}
+
+			visited[resource] = true
+			fn(resource)
+			walk(resource.AssetChecks)
+		}
+	}
+
+	walkPages(page, func(p *Page) {
+		walk(p.AssetChecks)
+	})
+}
+
+// Resource stores the availability of an URL that is referenced by a page but isn't crawled,
+// like a static asset. Stylesheets of the site can also be analyzed to find their own
+// dependencies (see CrawlOptions.CrawlStylesheets)
+type Resource struct {
+	URL          string  // Address of the resource
+	StatusCode   int     // HTTP status code of the response, zero when unknown
+	Fail         bool    // Flag to indicate that the resource isn't available
+	Error        string  // Reason of the failure when it isn't an HTTP status code
+	Stylesheet   bool    // Flag to indicate that the content was analyzed as a stylesheet
+	StaticAssets []Asset // Fonts, images and stylesheets referenced by the stylesheet
+
+	// AssetChecks stores the resources of the stylesheet dependencies, indexed by the asset URL
+	// (see Page.AssetChecks)
+	AssetChecks map[string]*Resource
+}
+
+// setResponse copies the result of the availability check to the resource. A status code
+// that represents an error (4xx or 5xx) marks the resource as failed
+func (r *Resource) setResponse(response *Response) {
+	r.StatusCode = response.StatusCode
+	if r.StatusCode >= 400 {
+		r.Fail = true
+	}
+}
+
 // Link stores information of other URL in this page
 type Link struct {
 	Label      string // Context identification of the link
+	Href       string // Original value of the href attribute, before any resolution
+	Nofollow   bool   // Flag to indicate that the link has the rel="nofollow" attribute
+	Sitemap    bool   // Flag to indicate that the link is a seed found in the sitemaps
 	Page       *Page  // Page information about the other URL
 	CyclicPage bool   // Flag to indicate if this page was already processed
+
+	// Refresh is true when the link is the target of a <meta http-equiv="refresh"> element,
+	// that redirects the browser to the page after the RefreshDelay
+	Refresh      bool
+	RefreshDelay time.Duration
+}
+
+// Redirect is a hop of a chain of redirects
+type Redirect struct {
+	URL        string // Address that was requested
+	StatusCode int    // HTTP status code of the redirect (301, 302, 303, 307 or 308)
+	Location   string // Address where the request was redirected to
+}
+
+// Response stores the content of a retrieved page together with the metadata of the
+// retrieval
+type Response struct {
+	URL           string        // Final address of the page, after following redirects
+	StatusCode    int           // HTTP status code, zero when unknown
+	ContentType   string        // Media type of the content
+	ContentLength int64         // Size of the content in bytes, -1 when unknown
+	Header        http.Header   // Response headers
+	Duration      time.Duration // Time until the headers were received, before reading the body
+	Attempts      int           // Number of requests sent to retrieve the page, zero when unknown
+	Redirects     []Redirect    // Redirects followed from the requested URL until the final URL
+
+	// Body is the content of the page. When it's also an io.Closer the content could be streamed
+	// from the network, and it must be closed after use
+	Body io.Reader
 }
 
 // Fetcher creates an interface to allow a flexibility on how we retrieve the page data. For tests
 // we will simulate the response while in production we will do a HTTP GET
 type Fetcher interface {
-	Fetch(url string) (io.Reader, error)
+	Fetch(url string) (*Response, error)
+}
+
+// ContextFetcher is a Fetcher that can abort the retrieval of the page data when the context
+// is cancelled or its deadline is reached
+type ContextFetcher interface {
+	Fetcher
+	FetchContext(ctx context.Context, url string) (*Response, error)
+}
+
+// Checker creates an interface to verify if an URL is available without retrieving all the
+// content. When the Fetcher also implements Checker it's used to verify external links and
+// static assets
+type Checker interface {
+	Check(ctx context.Context, url string) (*Response, error)
 }
 
-// HTTPFetcher will retrieve the page content via HTTP GET request
+// HTTPFetcher will retrieve the page content via HTTP GET request. The zero value is ready to
+// use, sending the requests with a client limited by DefaultHTTPTimeout
 type HTTPFetcher struct {
+	Client    *http.Client // Client that sends the requests (see NewHTTPClient)
+	UserAgent string       // Value of the User-Agent header, the Go default when empty
+	Header    http.Header  // Additional headers sent in all requests
 }
 
-func (f HTTPFetcher) Fetch(url string) (io.Reader, error) {
-	response, err := http.Get(url)
+// Fetch retrieves the page content without any deadline
+func (f HTTPFetcher) Fetch(url string) (*Response, error) {
+	return f.FetchContext(context.Background(), url)
+}
+
+// FetchContext retrieves the page content, aborting the request when the context is done.
+// Responses with error status codes are returned without error, so the caller can analyze
+// them. The content is streamed from the network while it's read, so the caller can analyze
+// the headers before deciding to download it, and must close the Body after use
+func (f HTTPFetcher) FetchContext(ctx context.Context, url string) (*Response, error) {
+	begin := time.Now()
+
+	response, redirects, err := f.do(ctx, "GET", url)
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
 
-	content, err := ioutil.ReadAll(response.Body)
+	r := newResponse(response, begin)
+	r.Redirects = redirects
+	r.Body = response.Body
+	return r, nil
+}
+
+// Check verifies if the URL is available using a HEAD request. As some servers don't support
+// HEAD requests, when it fails a GET request is sent, but the content is never retrieved
+func (f HTTPFetcher) Check(ctx context.Context, url string) (*Response, error) {
+	begin := time.Now()
+
+	response, redirects, err := f.do(ctx, "HEAD", url)
+	if err == nil {
+		response.Body.Close()
+		if response.StatusCode < 400 {
+			r := newResponse(response, begin)
+			r.Redirects = redirects
+			return r, nil
+		}
+	}
+
+	response, redirects, err = f.do(ctx, "GET", url)
 	if err != nil {
 		return nil, err
 	}
+	response.Body.Close()
 
-	return bytes.NewReader(content), nil
+	r := newResponse(response, begin)
+	r.Redirects = redirects
+	return r, nil
 }
 
-// CrawlerContext stores all attributes used during a crawling execution
+// do sends the HTTP request with the given method, returning the redirects that were followed
+// until the final response. A redirect to an address already visited in the chain is aborted
+// with ErrRedirectLoop
+func (f HTTPFetcher) do(ctx context.Context, method, url string) (*http.Response, []Redirect, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key, values := range f.Header {
+		request.Header[http.CanonicalHeaderKey(key)] = values
+	}
+
+	if len(f.UserAgent) > 0 {
+		request.Header.Set("User-Agent", f.UserAgent)
+	}
+
+	client := defaultHTTPClient
+	if f.Client != nil {
+		client = f.Client
+	}
+
+	// The client is copied to record the redirects of this request without changing the
+	// behaviour of the original client, that could be shared
+	var redirects []Redirect
+	recorder := *client
+	recorder.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		redirects = append(redirects, Redirect{
+			URL:        via[len(via)-1].URL.String(),
+			StatusCode: request.Response.StatusCode,
+			Location:   request.URL.String(),
+		})
+
+		for _, previous := range via {
+			if previous.URL.String() == request.URL.String() {
+				return ErrRedirectLoop
+			}
+		}
+
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(request, via)
+		}
+
+		// Same limit used by the default policy of the http.Client
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	response, err := recorder.Do(request)
+	return response, redirects, err
+}
+
+// newResponse copies the metadata of the HTTP response. The body isn't copied
+func newResponse(response *http.Response, begin time.Time) *Response {
+	return &Response{
+		URL:           response.Request.URL.String(),
+		StatusCode:    response.StatusCode,
+		ContentType:   response.Header.Get("Content-Type"),
+		ContentLength: response.ContentLength,
+		Header:        response.Header,
+		Duration:      time.Since(begin),
+	}
+}
+
+// CrawlOptions stores the parameters that control a crawling execution. Zero values for the
+// limits mean that there's no limit
+type CrawlOptions struct {
+	MaxConcurrency   int           // Maximum number of pages being fetched at the same time
+	MaxDepth         int           // Maximum number of links followed from the start page
+	MaxPages         int           // Maximum number of pages crawled
+	Timeout          time.Duration // Maximum duration of the crawl, running fetches are cancelled
+	Scope            *Scope        // Pages that are crawled, when nil it's built from the start URL
+	Normalizer       Normalizer    // Canonical form of the URLs, when nil the URL isn't normalized
+	Headers          []string      // Response headers stored in the crawled pages
+	CheckResources   bool          // Verify the availability of external links and static assets
+	UserAgent        string        // Product token used to select the rules of the robots.txt
+	IgnoreRobots     bool          // Crawl the pages disallowed by the robots.txt of the site
+	MaxBodySize      int64         // Maximum number of bytes of a page analyzed
+	IgnoreNofollow   bool          // Follow the links marked as nofollow by the pages
+	DedupeCanonical  bool          // Crawl only one page for each canonical URL
+	CrawlStylesheets bool          // Analyze the stylesheets of the site to find their dependencies
+	UseSitemaps      bool          // Also crawl the pages listed in the sitemaps of the site
+}
+
+// DefaultHeaders lists the response headers stored in the crawled pages by default
+var DefaultHeaders = []string{
+	"Cache-Control",
+	"ETag",
+	"Last-Modified",
+	"Server",
+}
+
+// DefaultCrawlOptions make it easy to initialize the options with the values used by the Crawl
+// function
+func DefaultCrawlOptions() CrawlOptions {
+	return CrawlOptions{
+		MaxConcurrency: DefaultMaxConcurrency,
+		Normalizer:     NewURLNormalizer(),
+		Headers:        DefaultHeaders,
+		UserAgent:      DefaultRobotsUserAgent,
+		MaxBodySize:    DefaultMaxBodySize,
+	}
+}
+
+// CrawlerContext stores all attributes used during a crawling execution. It embeds the
+// context.Context of the execution, that is done when the crawl is cancelled or the timeout
+// is reached
 type CrawlerContext struct {
-	Domain  string
-	Fetcher Fetcher
-	WG      sync.WaitGroup
+	context.Context
+
+	Scope      *Scope
+	Fetcher    Fetcher
+	Normalizer Normalizer
+	Options    CrawlOptions
+	WG         sync.WaitGroup
+
+	// semaphore controls the number of go routines fetching pages at the same time. Each crawl
+	// has its own semaphore, so independent crawls don't starve each other
+	semaphore chan int
+
+	// cancel releases the resources of the embedded context when the crawl finishes
+	cancel context.CancelFunc
+
+	// crawledPages counts the number of pages scheduled to be crawled, to respect the limit of
+	// pages. The crawledPagesLock allows it to be manipulated safely by go routines
+	crawledPages     int
+	crawledPagesLock sync.Mutex
 
 	// visitedPages store all pages already visited in a map, so that if we found a link for the same
 	// page again, we just pick on the map the same object address. The function that prints the page
-	// is responsable for detecting cycle loops
+	// is responsable for detecting cycle loops. The map is indexed by the normalized URL, so
+	// different representations of the same address are detected
 	visitedPages map[string]*Page
 
 	// visitedPagesLock allows visitedPages to be manipulated safely by go routines
 	visitedPagesLock sync.RWMutex
+
+	// externalPages store the pages outside the scope of the crawl, indexed by the normalized
+	// URL, so that all links to the same external page share the same object and the page is
+	// checked only once. The externalPagesLock allows it to be manipulated safely by go routines
+	externalPages     map[string]*Page
+	externalPagesLock sync.Mutex
+
+	// resources store the static assets checked during the crawl, indexed by the normalized URL,
+	// so that an asset used by many pages is checked only once. The resourcesLock allows it to
+	// be manipulated safely by go routines
+	resources     map[string]*Resource
+	resourcesLock sync.Mutex
+
+	// robots stores the robots.txt of each host of the scope, indexed by the scheme and the
+	// host, as each host has its own rules and crawl delay. The robotsLock allows it to be
+	// manipulated safely by go routines
+	robots     map[string]*hostRobots
+	robotsLock sync.Mutex
+
+	// scheduledPages store the pages found in the current depth level that will be crawled in
+	// the next level (see crawlLevels). The scheduledPagesLock allows it to be manipulated
+	// safely by go routines
+	scheduledPages     []*Page
+	scheduledPagesLock sync.Mutex
 }
 
-// NewCrawlerContext make it easy to initialize a new context
-func NewCrawlerContext(domain string, fetcher Fetcher) *CrawlerContext {
+// hostRobots stores the robots.txt of a host of the crawl, that is retrieved only once, and
+// the moment when the next page of the host can be fetched respecting the Crawl-delay
+type hostRobots struct {
+	load          sync.Once
+	robots        *Robots // Rules of the host, nil when there are no restrictions
+	nextFetch     time.Time
+	nextFetchLock sync.Mutex
+}
+
+// NewCrawlerContext make it easy to initialize a new context derived from ctx. When the
+// maximum concurrency isn't defined in the options DefaultMaxConcurrency is used
+func NewCrawlerContext(ctx context.Context, scope *Scope, fetcher Fetcher,
+	options CrawlOptions) *CrawlerContext {
+
 	c := &CrawlerContext{
-		Domain:  domain,
-		Fetcher: fetcher,
+		Scope:      scope,
+		Fetcher:    fetcher,
+		Normalizer: options.Normalizer,
+		Options:    options,
+	}
+
+	maxConcurrency := options.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = DefaultMaxConcurrency
+	}
+
+	c.semaphore = make(chan int, maxConcurrency)
+	for i := 0; i < maxConcurrency; i++ {
+		c.semaphore <- 1
+	}
+
+	if options.Timeout > 0 {
+		c.Context, c.cancel = context.WithTimeout(ctx, options.Timeout)
+	} else {
+		c.Context, c.cancel = context.WithCancel(ctx)
 	}
 
 	c.visitedPages = make(map[string]*Page)
+	c.externalPages = make(map[string]*Page)
+	c.resources = make(map[string]*Resource)
+	c.robots = make(map[string]*hostRobots)
 	return c
 }
 
-// VisitPage is a go routine safe way to add a new item in the visitedPages map
-func (c *CrawlerContext) VisitPage(page *Page) {
+// VisitPage is a go routine safe way to add a new item in the visitedPages map. The check and
+// the insertion are done atomically, so when the URL was already visited by another go routine
+// the stored page is returned and the visited flag is true
+func (c *CrawlerContext) VisitPage(page *Page) (*Page, bool) {
+	key := normalizeURL(c.Normalizer, page.URL)
+
 	c.visitedPagesLock.Lock()
 	defer c.visitedPagesLock.Unlock()
-	c.visitedPages[page.URL] = page
+
+	if visitedPage, visited := c.visitedPages[key]; visited {
+		return visitedPage, true
+	}
+
+	c.visitedPages[key] = page
+	return page, false
+}
+
+// VisitFinalURL registers the address where the page content was really found after following
+// redirects. When another page was already registered with the same address, the stored page
+// is returned and the duplicated flag is true, so the same content isn't analyzed twice
+func (c *CrawlerContext) VisitFinalURL(page *Page) (*Page, bool) {
+	return c.visitAlias(page, page.FinalURL)
+}
+
+// VisitCanonical registers the canonical URL declared by the page (see
+// CrawlOptions.DedupeCanonical). When another page was already registered with the same
+// address, the stored page is returned and the duplicated flag is true
+func (c *CrawlerContext) VisitCanonical(page *Page) (*Page, bool) {
+	return c.visitAlias(page, page.Canonical)
+}
+
+// visitAlias registers another address of the page in the visitedPages map, unless it was
+// already registered for a different page
+func (c *CrawlerContext) visitAlias(page *Page, address string) (*Page, bool) {
+	if len(address) == 0 {
+		return page, false
+	}
+
+	key := normalizeURL(c.Normalizer, address)
+
+	c.visitedPagesLock.Lock()
+	defer c.visitedPagesLock.Unlock()
+
+	if visitedPage, visited := c.visitedPages[key]; visited && visitedPage != page {
+		return visitedPage, true
+	}
+
+	c.visitedPages[key] = page
+	return page, false
 }
 
 // URLWasVisited is a go routine safe way to check if a page was alredy analyzed
 func (c *CrawlerContext) URLWasVisited(url string) (*Page, bool) {
+	key := normalizeURL(c.Normalizer, url)
+
 	c.visitedPagesLock.RLock()
 	defer c.visitedPagesLock.RUnlock()
-	page, visited := c.visitedPages[url]
+	page, visited := c.visitedPages[key]
 	return page, visited
 }
+
+// ExternalPage is a go routine safe way to register a page outside the scope of the crawl. If
+// the URL was already registered the stored page is returned and the flag is false, otherwise
+// the given page is returned and the flag is true
+func (c *CrawlerContext) ExternalPage(page *Page) (*Page, bool) {
+	key := normalizeURL(c.Normalizer, page.URL)
+
+	c.externalPagesLock.Lock()
+	defer c.externalPagesLock.Unlock()
+
+	if externalPage, found := c.externalPages[key]; found {
+		return externalPage, false
+	}
+
+	c.externalPages[key] = page
+	return page, true
+}
+
+// Resource is a go routine safe way to retrieve the resource of the URL, creating it when
+// it doesn't exist yet. The flag is true when the resource was created
+func (c *CrawlerContext) Resource(url string) (*Resource, bool) {
+	key := normalizeURL(c.Normalizer, url)
+
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+
+	if resource, found := c.resources[key]; found {
+		return resource, false
+	}
+
+	resource := &Resource{URL: url}
+	c.resources[key] = resource
+	return resource, true
+}
+
+// fetch retrieves the page data using the context of the crawl when the fetcher supports it
+func (c *CrawlerContext) fetch(url string) (*Response, error) {
+	return fetchContext(c, c.Fetcher, url)
+}
+
+// check verifies the availability of the URL. When the fetcher isn't a Checker the content is
+// retrieved and discarded
+func (c *CrawlerContext) check(url string) (*Response, error) {
+	if checker, ok := c.Fetcher.(Checker); ok {
+		return checker.Check(c, url)
+	}
+
+	return c.fetch(url)
+}
+
+// acquire waits for a free slot to fetch a page. It returns false when the crawl was cancelled
+// before a slot was available, and in this case the slot must not be released
+func (c *CrawlerContext) acquire() bool {
+	// Don't wait for a free slot when the crawl was already cancelled
+	select {
+	case <-c.semaphore:
+	case <-c.Done():
+		return false
+	}
+
+	if c.Err() != nil {
+		c.release()
+		return false
+	}
+
+	return true
+}
+
+// release frees the slot acquired to fetch a page
+func (c *CrawlerContext) release() {
+	c.semaphore <- 1
+}
+
+// schedule is a go routine safe way to add a page to the next depth level of the crawl
+func (c *CrawlerContext) schedule(page *Page) {
+	c.scheduledPagesLock.Lock()
+	defer c.scheduledPagesLock.Unlock()
+
+	c.scheduledPages = append(c.scheduledPages, page)
+}
+
+// nextLevel returns the scheduled pages, starting a new depth level
+func (c *CrawlerContext) nextLevel() []*Page {
+	c.scheduledPagesLock.Lock()
+	defer c.scheduledPagesLock.Unlock()
+
+	pages := c.scheduledPages
+	c.scheduledPages = nil
+	return pages
+}
+
+// exceedsDepth checks if a page in the given depth is beyond the maximum depth of the crawl
+func (c *CrawlerContext) exceedsDepth(depth int) bool {
+	return c.Options.MaxDepth > 0 && depth > c.Options.MaxDepth
+}
+
+// reservePage is a go routine safe way to count a new page to be crawled. It returns false
+// when the limit of pages was reached, and the page shouldn't be crawled
+func (c *CrawlerContext) reservePage() bool {
+	c.crawledPagesLock.Lock()
+	defer c.crawledPagesLock.Unlock()
+
+	if c.Options.MaxPages > 0 && c.crawledPages >= c.Options.MaxPages {
+		return false
+	}
+
+	c.crawledPages++
+	return true
+}
+
+// hostRobots returns the robots.txt of the URL host, retrieving it when the host is found for
+// the first time. Nil is returned when the rules are ignored or the URL is outside the scope
+func (c *CrawlerContext) hostRobots(rawURL string) *hostRobots {
+	if c.Options.IgnoreRobots || !c.Scope.Contains(rawURL) {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	key := strings.ToLower(u.Scheme + "://" + u.Host)
+
+	c.robotsLock.Lock()
+	robots, found := c.robots[key]
+	if !found {
+		robots = new(hostRobots)
+		c.robots[key] = robots
+	}
+	c.robotsLock.Unlock()
+
+	// Other go routines that find the same host wait until the file is retrieved
+	robots.load.Do(func() {
+		robots.robots = loadRobots(c, rawURL)
+	})
+	return robots
+}
+
+// robotsAllowed checks if the robots.txt of the URL host allows the URL to be crawled. URLs
+// outside the scope, or that can't be parsed, are always allowed
+func (c *CrawlerContext) robotsAllowed(rawURL string) bool {
+	hostRobots := c.hostRobots(rawURL)
+	if hostRobots == nil || hostRobots.robots == nil {
+		return true
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+
+	path := u.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(u.RawQuery) > 0 {
+		path += "?" + u.RawQuery
+	}
+
+	return hostRobots.robots.Allowed(path)
+}
+
+// waitCrawlDelay blocks until the URL can be fetched respecting the Crawl-delay of the
+// robots.txt of its host. Each call reserves its own moment, so concurrent fetches of the same
+// host are spaced by the delay. It returns false when the crawl was cancelled while waiting
+func (c *CrawlerContext) waitCrawlDelay(rawURL string) bool {
+	hostRobots := c.hostRobots(rawURL)
+	if hostRobots == nil || hostRobots.robots == nil || hostRobots.robots.CrawlDelay <= 0 {
+		return true
+	}
+
+	hostRobots.nextFetchLock.Lock()
+	now := time.Now()
+	fetchAt := hostRobots.nextFetch
+	if fetchAt.Before(now) {
+		fetchAt = now
+	}
+	hostRobots.nextFetch = fetchAt.Add(hostRobots.robots.CrawlDelay)
+	hostRobots.nextFetchLock.Unlock()
+
+	timer := time.NewTimer(fetchAt.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Done():
+		return false
+	}
+}
@@ -5,10 +5,16 @@
 package crawler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPageString(t *testing.T) {
@@ -30,10 +36,10 @@ func TestPageString(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: `
@@ -75,10 +81,10 @@ func TestPageString(t *testing.T) {
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: `
@@ -116,10 +122,10 @@ func TestPageString(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: `
@@ -137,6 +143,94 @@ func TestPageString(t *testing.T) {
   
     ❆ example2.html
     
+`,
+		},
+
+		// Page with skipped links test
+		{
+			page: Page{
+				URL: "index.html",
+				Links: []Link{
+					{
+						Label: "Example 1",
+						Page: &Page{
+							URL:     "example1.html",
+							Depth:   1,
+							Skipped: SkipMaxDepth,
+						},
+					},
+				},
+			},
+			expected: `
+❆ index.html
+
+  ↳ "Example 1"
+  
+    ❆ example1.html ⊘ (max depth)
+    
+`,
+		},
+
+		// Page with status code test
+		{
+			page: Page{
+				URL: "index.html",
+				Links: []Link{
+					{
+						Label: "Example 1",
+						Page: &Page{
+							URL:        "example1.html",
+							Fail:       true,
+							StatusCode: 404,
+						},
+					},
+				},
+			},
+			expected: `
+❆ index.html
+
+  ↳ "Example 1"
+  
+    ❆ example1.html ✗ (404)
+    
+`,
+		},
+
+		// Page with typed static assets test
+		{
+			page: Page{
+				URL: "index.html",
+				StaticAssets: []Asset{
+					{URL: "example.css", Kind: AssetStylesheet},
+					{URL: "example.png", Kind: AssetImage},
+				},
+			},
+			expected: `
+❆ index.html
+
+  ▤  example.css [stylesheet]
+  ▤  example.png [image]
+`,
+		},
+
+		// Page with sitemap seeds test
+		{
+			page: Page{
+				URL: "index.html",
+				Seeds: []Link{
+					{
+						Label: "<sitemap>",
+						Page:  &Page{URL: "orphan.html"},
+					},
+				},
+			},
+			expected: `
+❆ index.html
+
+  ↳ "<sitemap>"
+  
+    ❆ orphan.html
+    
 `,
 		},
 	}
@@ -169,10 +263,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -187,10 +281,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: true,
@@ -210,10 +304,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example1.css",
-					"example1.js",
-					"example1.png",
+				StaticAssets: []Asset{
+					{URL: "example1.css"},
+					{URL: "example1.js"},
+					{URL: "example1.png"},
 				},
 			},
 			page2: Page{
@@ -228,10 +322,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example2.css",
-					"example2.js",
-					"example2.png",
+				StaticAssets: []Asset{
+					{URL: "example2.css"},
+					{URL: "example2.js"},
+					{URL: "example2.png"},
 				},
 			},
 			expected: false,
@@ -251,10 +345,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -265,10 +359,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example1.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: false,
@@ -288,10 +382,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -306,10 +400,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example2.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: false,
@@ -326,10 +420,10 @@ func TestPageComparison(t *testing.T) {
 						Page:       &Page{URL: "example1.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -341,10 +435,10 @@ func TestPageComparison(t *testing.T) {
 						Page:       &Page{URL: "example1.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: false,
@@ -360,10 +454,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example1.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -374,10 +468,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  nil,
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: false,
@@ -393,10 +487,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  nil,
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -407,10 +501,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  &Page{URL: "example1.html"},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: false,
@@ -426,10 +520,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  nil,
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -440,10 +534,10 @@ func TestPageComparison(t *testing.T) {
 						Page:  nil,
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: true,
@@ -458,18 +552,18 @@ func TestPageComparison(t *testing.T) {
 						Label: "Example 1",
 						Page: &Page{
 							URL: "example1.html",
-							StaticAssets: []string{
-								"example1.css",
-								"example1.js",
-								"example1.png",
+							StaticAssets: []Asset{
+								{URL: "example1.css"},
+								{URL: "example1.js"},
+								{URL: "example1.png"},
 							},
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -479,18 +573,18 @@ func TestPageComparison(t *testing.T) {
 						Label: "Example 1",
 						Page: &Page{
 							URL: "example2.html",
-							StaticAssets: []string{
-								"example2.css",
-								"example2.js",
-								"example2.png",
+							StaticAssets: []Asset{
+								{URL: "example2.css"},
+								{URL: "example2.js"},
+								{URL: "example2.png"},
 							},
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: false,
@@ -506,18 +600,18 @@ func TestPageComparison(t *testing.T) {
 						CyclicPage: true,
 						Page: &Page{
 							URL: "example1.html",
-							StaticAssets: []string{
-								"example1.css",
-								"example1.js",
-								"example1.png",
+							StaticAssets: []Asset{
+								{URL: "example1.css"},
+								{URL: "example1.js"},
+								{URL: "example1.png"},
 							},
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			page2: Page{
@@ -528,22 +622,56 @@ func TestPageComparison(t *testing.T) {
 						CyclicPage: true,
 						Page: &Page{
 							URL: "example2.html",
-							StaticAssets: []string{
-								"example2.css",
-								"example2.js",
-								"example2.png",
+							StaticAssets: []Asset{
+								{URL: "example2.css"},
+								{URL: "example2.js"},
+								{URL: "example2.png"},
 							},
 						},
 					},
 				},
-				StaticAssets: []string{
-					"example.css",
-					"example.js",
-					"example.png",
+				StaticAssets: []Asset{
+					{URL: "example.css"},
+					{URL: "example.js"},
+					{URL: "example.png"},
 				},
 			},
 			expected: true,
 		},
+
+		// Different static asset details test
+		{
+			page1: Page{
+				URL: "index.html",
+				StaticAssets: []Asset{
+					{URL: "example.css", Kind: AssetStylesheet, Tag: "link", Rel: "stylesheet"},
+				},
+			},
+			page2: Page{
+				URL: "index.html",
+				StaticAssets: []Asset{
+					{URL: "example.css", Kind: AssetStylesheet, Tag: "link", Rel: "preload"},
+				},
+			},
+			expected: false,
+		},
+
+		// Different sitemap seeds test
+		{
+			page1: Page{
+				URL: "index.html",
+				Seeds: []Link{
+					{Label: "<sitemap>", Page: &Page{URL: "orphan1.html"}},
+				},
+			},
+			page2: Page{
+				URL: "index.html",
+				Seeds: []Link{
+					{Label: "<sitemap>", Page: &Page{URL: "orphan2.html"}},
+				},
+			},
+			expected: false,
+		},
 	}
 
 	for _, testItem := range testData {
@@ -586,10 +714,10 @@ func TestHTTPFetcher(t *testing.T) {
 				},
 			},
 		},
-		StaticAssets: []string{
-			"example.css",
-			"example.png",
-			"example.js",
+		StaticAssets: []Asset{
+			{URL: "example.css"},
+			{URL: "example.png"},
+			{URL: "example.js"},
 		},
 	})
 
@@ -602,6 +730,268 @@ func TestHTTPFetcher(t *testing.T) {
 	}
 }
 
+func TestHTTPFetcherMustReturnMetadata(t *testing.T) {
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+
+		case "/new":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+			fmt.Fprint(w, "<html><body></body></html>")
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer httpTestServer.Close()
+
+	url := fmt.Sprintf("http://%s", httpTestServer.Listener.Addr().String())
+
+	response, err := (HTTPFetcher{}).Fetch(url + "/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.URL != url+"/new" ||
+		response.StatusCode != http.StatusOK ||
+		response.ContentType != "text/html; charset=utf-8" ||
+		response.ContentLength != int64(len("<html><body></body></html>")) ||
+		response.Header.Get("Last-Modified") != "Wed, 21 Oct 2015 07:28:00 GMT" ||
+		response.Duration <= 0 {
+
+		t.Errorf("Unexpected response metadata: %+v", response)
+	}
+
+	response, err = (HTTPFetcher{}).Fetch(url + "/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected status code. Expected %d and got %d",
+			http.StatusNotFound, response.StatusCode)
+	}
+}
+
+func TestHTTPFetcherMustStreamContent(t *testing.T) {
+	release := make(chan bool)
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer httpTestServer.Close()
+	defer close(release)
+
+	// The server only sends the content after the release, so the response must be returned
+	// with only the headers
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+
+		response, err := (HTTPFetcher{}).Fetch(httpTestServer.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		closer, ok := response.Body.(io.Closer)
+		if !ok {
+			t.Error("Streamed content can't be closed")
+			return
+		}
+		closer.Close()
+
+		if response.ContentType != "application/octet-stream" || response.ContentLength != -1 {
+			t.Errorf("Unexpected response metadata: %+v", response)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Response only returned after the content was sent")
+	}
+}
+
+func TestHTTPFetcherMustRecordRedirects(t *testing.T) {
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusFound)
+		case "/c":
+			fmt.Fprint(w, "<html><body></body></html>")
+		case "/loop1":
+			http.Redirect(w, r, "/loop2", http.StatusFound)
+		case "/loop2":
+			http.Redirect(w, r, "/loop1", http.StatusFound)
+		}
+	}))
+	defer httpTestServer.Close()
+
+	url := httpTestServer.URL
+	expected := []Redirect{
+		{URL: url + "/a", StatusCode: http.StatusMovedPermanently, Location: url + "/b"},
+		{URL: url + "/b", StatusCode: http.StatusFound, Location: url + "/c"},
+	}
+
+	response, err := (HTTPFetcher{}).Fetch(url + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.URL != url+"/c" || !reflect.DeepEqual(response.Redirects, expected) {
+		t.Errorf("Unexpected redirects. Expected '%v' and got '%v'", expected, response.Redirects)
+	}
+
+	response, err = (HTTPFetcher{}).Check(context.Background(), url+"/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(response.Redirects, expected) {
+		t.Errorf("Unexpected redirects on check. Expected '%v' and got '%v'",
+			expected, response.Redirects)
+	}
+
+	if _, err := (HTTPFetcher{}).Fetch(url + "/loop1"); !errors.Is(err, ErrRedirectLoop) {
+		t.Errorf("Unexpected error returned. Expected '%v' and got '%v'", ErrRedirectLoop, err)
+	}
+
+	// The redirect policy of the client must be respected
+	fetcher := HTTPFetcher{
+		Client: &http.Client{
+			CheckRedirect: func(request *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	response, err = fetcher.Fetch(url + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusMovedPermanently || len(response.Redirects) != 1 {
+		t.Errorf("Redirect policy of the client not respected: %+v", response)
+	}
+}
+
+func TestHTTPFetcherMustSendHeaders(t *testing.T) {
+	var header http.Header
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		fmt.Fprint(w, "<html><body></body></html>")
+	}))
+	defer httpTestServer.Close()
+
+	fetcher := HTTPFetcher{
+		UserAgent: "crawler/0.2",
+		Header: http.Header{
+			"Accept-Language": []string{"pt-BR"},
+			"x-token":         []string{"secret"},
+		},
+	}
+
+	if _, err := fetcher.Fetch(httpTestServer.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("User-Agent") != "crawler/0.2" ||
+		header.Get("Accept-Language") != "pt-BR" ||
+		header.Get("X-Token") != "secret" {
+		t.Errorf("Unexpected request headers: %v", header)
+	}
+
+	if _, err := (HTTPFetcher{}).Check(context.Background(), httpTestServer.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.HasPrefix(header.Get("User-Agent"), "crawler") || header.Get("X-Token") != "" {
+		t.Errorf("Unexpected request headers with the default fetcher: %v", header)
+	}
+}
+
+func TestHTTPFetcherCheck(t *testing.T) {
+	var methods []string
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprint(w, "<html><body></body></html>")
+
+		case "/no-head":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			fmt.Fprint(w, "<html><body></body></html>")
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer httpTestServer.Close()
+
+	url := fmt.Sprintf("http://%s", httpTestServer.Listener.Addr().String())
+
+	testData := []struct {
+		path       string
+		statusCode int
+		methods    []string
+	}{
+		{path: "/ok", statusCode: http.StatusOK, methods: []string{"HEAD"}},
+		{path: "/no-head", statusCode: http.StatusOK, methods: []string{"HEAD", "GET"}},
+		{path: "/missing", statusCode: http.StatusNotFound, methods: []string{"HEAD", "GET"}},
+	}
+
+	for _, testItem := range testData {
+		methods = nil
+
+		response, err := (HTTPFetcher{}).Check(context.Background(), url+testItem.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != testItem.statusCode {
+			t.Errorf("Unexpected status code for '%s'. Expected %d and got %d",
+				testItem.path, testItem.statusCode, response.StatusCode)
+		}
+
+		if !reflect.DeepEqual(methods, testItem.methods) {
+			t.Errorf("Unexpected requests for '%s'. Expected %v and got %v",
+				testItem.path, testItem.methods, methods)
+		}
+	}
+
+	if _, err := (HTTPFetcher{}).Check(context.Background(), "http://unknownurl.unknown"); err == nil {
+		t.Error("No error returned when checking an invalid URL")
+	}
+}
+
+func TestHTTPFetcherMustRespectContext(t *testing.T) {
+	release := make(chan bool)
+	httpTestServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer httpTestServer.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s", httpTestServer.Listener.Addr().String())
+	if _, err := (HTTPFetcher{}).FetchContext(ctx, url); err == nil {
+		t.Error("Fetch not aborted when the context deadline was reached")
+	}
+}
+
 func BenchmarkPageToString(b *testing.B) {
 	page := Page{
 		URL: "index.html",
@@ -615,10 +1005,10 @@ func BenchmarkPageToString(b *testing.B) {
 				Page:  &Page{URL: "example2.html"},
 			},
 		},
-		StaticAssets: []string{
-			"example.css",
-			"example.js",
-			"example.png",
+		StaticAssets: []Asset{
+			{URL: "example.css"},
+			{URL: "example.js"},
+			{URL: "example.png"},
 		},
 	}
 
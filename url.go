@@ -0,0 +1,301 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"code.google.com/p/go.net/html"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baseURL detects the address that should be used to resolve the relative references of the
+// page. When the HTML document has a <base href> element it has precedence over the page
+// address (RFC 3986 section 5.1.1). A nil value is returned when no valid base was found
+func baseURL(root *html.Node, pageURL string) *url.URL {
+	base, err := url.Parse(strings.TrimSpace(pageURL))
+	if err != nil {
+		return nil
+	}
+
+	if href, found := findBaseHref(root); found {
+		if documentBase, err := url.Parse(href); err == nil {
+			return base.ResolveReference(documentBase)
+		}
+	}
+
+	return base
+}
+
+// findBaseHref travels the HTML document looking for the first <base> element with a href
+// attribute, as the HTML specification ignores all others
+func findBaseHref(node *html.Node) (string, bool) {
+	if node.Type == html.ElementNode && node.Data == "base" {
+		for _, attr := range node.Attr {
+			if attr.Key == "href" {
+				return strings.TrimSpace(attr.Val), true
+			}
+		}
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if href, found := findBaseHref(child); found {
+			return href, true
+		}
+	}
+
+	return "", false
+}
+
+// findCanonical travels the HTML document looking for the first <link rel="canonical">
+// element with a href attribute, that defines the preferred address of the page content
+func findCanonical(node *html.Node) (string, bool) {
+	if node.Type == html.ElementNode && node.Data == "link" {
+		rel, _ := attribute(node, "rel")
+		for _, relType := range strings.Fields(strings.ToLower(rel)) {
+			if relType != "canonical" {
+				continue
+			}
+
+			if href, found := attribute(node, "href"); found && len(strings.TrimSpace(href)) > 0 {
+				return strings.TrimSpace(href), true
+			}
+		}
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if href, found := findCanonical(child); found {
+			return href, true
+		}
+	}
+
+	return "", false
+}
+
+// parseRefresh reads the content of a <meta http-equiv="refresh"> element, like
+// "5; url=/new.html", following the HTML specification algorithm. It returns the delay before
+// the refresh and the target address, that is empty when the page only reloads itself. The
+// flag is false when the content is invalid
+func parseRefresh(content string) (time.Duration, string, bool) {
+	const whitespace = " \t\n\r\f"
+	content = strings.TrimLeft(content, whitespace)
+
+	// Only the integer part of the delay is used, but the fraction is allowed
+	end := 0
+	for end < len(content) && content[end] >= '0' && content[end] <= '9' {
+		end++
+	}
+	if end == 0 && !strings.HasPrefix(content, ".") {
+		return 0, "", false
+	}
+
+	seconds, _ := strconv.Atoi(content[:end])
+	delay := time.Duration(seconds) * time.Second
+
+	content = strings.TrimLeft(content[end:], "0123456789.")
+	if len(content) > 0 && !strings.ContainsAny(content[:1], whitespace+";,") {
+		return 0, "", false
+	}
+
+	content = strings.TrimLeft(content, whitespace)
+	if len(content) > 0 && (content[0] == ';' || content[0] == ',') {
+		content = strings.TrimLeft(content[1:], whitespace)
+	}
+
+	// The "url=" prefix is optional
+	if len(content) >= 3 && strings.EqualFold(content[:3], "url") {
+		rest := strings.TrimLeft(content[3:], whitespace)
+		if strings.HasPrefix(rest, "=") {
+			content = strings.TrimLeft(rest[1:], whitespace)
+		}
+	}
+
+	if len(content) > 0 && (content[0] == '"' || content[0] == '\'') {
+		quote := content[0]
+		content = content[1:]
+		if index := strings.IndexByte(content, quote); index >= 0 {
+			content = content[:index]
+		}
+	}
+
+	return delay, strings.TrimSpace(content), true
+}
+
+// resolveURL converts a reference found in the page (relative path, query, protocol-relative
+// address, etc.) into an absolute URL using the base address, following the RFC 3986 section
+// 5.2 algorithm. If the reference or the base are invalid the reference is returned untouched
+func resolveURL(base *url.URL, reference string) string {
+	reference = strings.TrimSpace(reference)
+	if base == nil {
+		return reference
+	}
+
+	ref, err := url.Parse(reference)
+	if err != nil {
+		return reference
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
+// isHTTP checks if the URL uses the HTTP or HTTPS scheme, so that it can be retrieved by the
+// crawler. Other references like "mailto:" or "javascript:" are ignored
+func isHTTP(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	return (scheme == "http" || scheme == "https") && len(u.Host) > 0
+}
+
+// DefaultTrackingParameters lists the query parameters commonly added by marketing tools to
+// identify the origin of a visit. They don't change the content of the page, so the default
+// normalizer drops them
+var DefaultTrackingParameters = []string{
+	"utm_source",
+	"utm_medium",
+	"utm_campaign",
+	"utm_term",
+	"utm_content",
+	"gclid",
+	"fbclid",
+}
+
+// DefaultIndexFiles lists the file names that web servers usually deliver when a directory
+// is requested
+var DefaultIndexFiles = []string{
+	"index.html",
+	"index.htm",
+}
+
+// Normalizer creates an interface to allow a flexibility on how URLs are converted into a
+// canonical form. The crawler uses the canonical form to detect pages that were already
+// visited
+type Normalizer interface {
+	Normalize(url string) string
+}
+
+// normalizeURL converts the URL into the canonical form of the normalizer. When there's no
+// normalizer the URL is returned untouched
+func normalizeURL(normalizer Normalizer, url string) string {
+	if normalizer == nil {
+		return url
+	}
+
+	return normalizer.Normalize(url)
+}
+
+// URLNormalizer is the default normalizer. It lowercases the scheme and the host, removes
+// the default port, the fragment, the dot segments and the index file of the path, drops
+// tracking parameters and sorts the query parameters
+type URLNormalizer struct {
+	TrackingParameters []string // Query parameters that are removed from the URL
+	IndexFiles         []string // Path file names that are equivalent to the directory
+}
+
+// NewURLNormalizer make it easy to initialize a normalizer with the default tracking
+// parameters and index files
+func NewURLNormalizer() URLNormalizer {
+	return URLNormalizer{
+		TrackingParameters: DefaultTrackingParameters,
+		IndexFiles:         DefaultIndexFiles,
+	}
+}
+
+// Normalize converts the URL into its canonical form. URLs that can't be parsed are returned
+// untouched
+func (n URLNormalizer) Normalize(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) ||
+		(u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+
+	if u.Opaque == "" {
+		u.Path = removeDotSegments(u.Path)
+		u.RawPath = ""
+
+		for _, indexFile := range n.IndexFiles {
+			if strings.HasSuffix(u.Path, "/"+indexFile) {
+				u.Path = strings.TrimSuffix(u.Path, indexFile)
+				break
+			}
+		}
+
+		// An empty path is equivalent to the root path when there's an authority (RFC 3986
+		// section 6.2.3)
+		if u.Path == "" && u.Host != "" {
+			u.Path = "/"
+		}
+	}
+
+	if u.RawQuery != "" {
+		query, err := url.ParseQuery(u.RawQuery)
+		if err == nil {
+			for key := range query {
+				for _, trackingParameter := range n.TrackingParameters {
+					if strings.EqualFold(key, trackingParameter) {
+						query.Del(key)
+						break
+					}
+				}
+			}
+
+			// Encode already sorts the query parameters by key
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+// removeDotSegments interprets and removes the "." and ".." segments from a path, as described
+// in RFC 3986 section 5.2.4
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	var output []string
+	segments := strings.Split(path, "/")
+
+	for i, segment := range segments {
+		last := (i == len(segments)-1)
+
+		switch segment {
+		case ".":
+			if last {
+				output = append(output, "")
+			}
+
+		case "..":
+			// Never remove the empty segment that represents the path root
+			if len(output) > 1 || (len(output) == 1 && output[0] != "") {
+				output = output[:len(output)-1]
+			}
+
+			if last {
+				output = append(output, "")
+			}
+
+		default:
+			output = append(output, segment)
+		}
+	}
+
+	return strings.Join(output, "/")
+}
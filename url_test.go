@@ -0,0 +1,234 @@
+// Copyright 2014 Rafael Dantas Justo. All rights reserved.
+// Use of this source code is governed by a GPL
+// license that can be found in the LICENSE file.
+
+package crawler
+
+import (
+	"code.google.com/p/go.net/html"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveURL(t *testing.T) {
+	base, err := url.Parse("http://example.com/docs/guide/index.html?page=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		reference string
+		expected  string
+	}{
+		{reference: "page2.html", expected: "http://example.com/docs/guide/page2.html"},
+		{reference: "../x.html", expected: "http://example.com/docs/x.html"},
+		{reference: "../../../../x.html", expected: "http://example.com/x.html"},
+		{reference: "./", expected: "http://example.com/docs/guide/"},
+		{reference: "/root.html", expected: "http://example.com/root.html"},
+		{reference: "?page=3", expected: "http://example.com/docs/guide/index.html?page=3"},
+		{reference: "#top", expected: "http://example.com/docs/guide/index.html?page=1#top"},
+		{reference: "//example.net/x", expected: "http://example.net/x"},
+		{reference: "https://example.org/", expected: "https://example.org/"},
+		{reference: "  page2.html  ", expected: "http://example.com/docs/guide/page2.html"},
+		{reference: "mailto:someone@example.com", expected: "mailto:someone@example.com"},
+		{reference: "%zz", expected: "%zz"},
+	}
+
+	for _, testItem := range testData {
+		if resolved := resolveURL(base, testItem.reference); resolved != testItem.expected {
+			t.Errorf("Unexpected URL resolving '%s'. Expected '%s' and got '%s'",
+				testItem.reference, testItem.expected, resolved)
+		}
+	}
+
+	if resolved := resolveURL(nil, " page2.html "); resolved != "page2.html" {
+		t.Errorf("Unexpected URL resolving without base. Expected 'page2.html' and got '%s'",
+			resolved)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	testData := []struct {
+		pageURL  string
+		data     string
+		expected string
+	}{
+		// No base element test
+		{
+			pageURL:  "http://example.com/docs/index.html",
+			data:     `<html><head></head><body></body></html>`,
+			expected: "http://example.com/docs/index.html",
+		},
+
+		// Absolute base element test
+		{
+			pageURL:  "http://example.com/docs/index.html",
+			data:     `<html><head><base href="http://static.example.com/v2/"></head></html>`,
+			expected: "http://static.example.com/v2/",
+		},
+
+		// Relative base element test
+		{
+			pageURL:  "http://example.com/docs/index.html",
+			data:     `<html><head><base href="../other/"></head></html>`,
+			expected: "http://example.com/other/",
+		},
+
+		// Base element without href test
+		{
+			pageURL:  "http://example.com/docs/index.html",
+			data:     `<html><head><base target="_blank"></head></html>`,
+			expected: "http://example.com/docs/index.html",
+		},
+
+		// Multiple base elements test
+		{
+			pageURL:  "http://example.com/docs/index.html",
+			data:     `<html><head><base href="/first/"><base href="/second/"></head></html>`,
+			expected: "http://example.com/first/",
+		},
+	}
+
+	for _, testItem := range testData {
+		root, err := html.Parse(strings.NewReader(testItem.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		base := baseURL(root, testItem.pageURL)
+		if base == nil {
+			t.Errorf("No base URL detected for '%s'", testItem.data)
+			continue
+		}
+
+		if base.String() != testItem.expected {
+			t.Errorf("Unexpected base URL. Expected '%s' and got '%s'",
+				testItem.expected, base)
+		}
+	}
+}
+
+func TestFindCanonical(t *testing.T) {
+	testData := []struct {
+		data          string
+		expected      string
+		expectedFound bool
+	}{
+		{data: `<html><head></head></html>`},
+		{
+			data:          `<html><head><link rel="canonical" href=" /page.html "></head></html>`,
+			expected:      "/page.html",
+			expectedFound: true,
+		},
+		{
+			data:          `<html><head><link rel="Canonical" href="/page.html"></head></html>`,
+			expected:      "/page.html",
+			expectedFound: true,
+		},
+		{data: `<html><head><link rel="canonical"><link rel="alternate" href="/a"></head></html>`},
+		{
+			data: `<html><head><link rel="canonical" href="/first">` +
+				`<link rel="canonical" href="/second"></head></html>`,
+			expected:      "/first",
+			expectedFound: true,
+		},
+	}
+
+	for _, testItem := range testData {
+		root, err := html.Parse(strings.NewReader(testItem.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		href, found := findCanonical(root)
+		if href != testItem.expected || found != testItem.expectedFound {
+			t.Errorf("Unexpected canonical for '%s'. Expected '%s' (%t) and got '%s' (%t)",
+				testItem.data, testItem.expected, testItem.expectedFound, href, found)
+		}
+	}
+}
+
+func TestParseRefresh(t *testing.T) {
+	testData := []struct {
+		content        string
+		expectedDelay  time.Duration
+		expectedTarget string
+		expectedOK     bool
+	}{
+		{content: "", expectedOK: false},
+		{content: "url=/page.html", expectedOK: false},
+		{content: "5", expectedDelay: 5 * time.Second, expectedOK: true},
+		{content: "0; url=/page.html", expectedTarget: "/page.html", expectedOK: true},
+		{content: " 3 ;URL = '/page.html'", expectedDelay: 3 * time.Second,
+			expectedTarget: "/page.html", expectedOK: true},
+		{content: `1.5, url="/a b.html" trailing`, expectedDelay: time.Second,
+			expectedTarget: "/a b.html", expectedOK: true},
+		{content: "0; http://example.net/", expectedTarget: "http://example.net/", expectedOK: true},
+		{content: "5url=/page.html", expectedOK: false},
+	}
+
+	for _, testItem := range testData {
+		delay, target, ok := parseRefresh(testItem.content)
+		if delay != testItem.expectedDelay || target != testItem.expectedTarget ||
+			ok != testItem.expectedOK {
+			t.Errorf("Unexpected refresh for '%s'. Expected %s, '%s' and %t and got %s, '%s' and %t",
+				testItem.content, testItem.expectedDelay, testItem.expectedTarget, testItem.expectedOK,
+				delay, target, ok)
+		}
+	}
+}
+
+func TestURLNormalizer(t *testing.T) {
+	testData := []struct {
+		url      string
+		expected string
+	}{
+		{url: "http://example.com", expected: "http://example.com/"},
+		{url: "http://example.com/", expected: "http://example.com/"},
+		{url: "HTTP://EXAMPLE.com/#top", expected: "http://example.com/"},
+		{url: "http://example.com/index.html?utm_source=x", expected: "http://example.com/"},
+		{url: "http://example.com:80/docs/", expected: "http://example.com/docs/"},
+		{url: "https://example.com:443/docs/", expected: "https://example.com/docs/"},
+		{url: "https://example.com:8443/docs/", expected: "https://example.com:8443/docs/"},
+		{url: "http://example.com/a/./b/../c", expected: "http://example.com/a/c"},
+		{url: "http://example.com/a/b/..", expected: "http://example.com/a/"},
+		{url: "http://example.com/Docs/Index.HTML", expected: "http://example.com/Docs/Index.HTML"},
+		{url: "http://example.com/?b=2&a=1&a=0", expected: "http://example.com/?a=1&a=0&b=2"},
+		{url: "http://example.com/?UTM_Medium=x&page=2&gclid=y", expected: "http://example.com/?page=2"},
+		{url: "mailto:someone@example.com", expected: "mailto:someone@example.com"},
+		{url: "%zz", expected: "%zz"},
+	}
+
+	normalizer := NewURLNormalizer()
+	for _, testItem := range testData {
+		if normalized := normalizer.Normalize(testItem.url); normalized != testItem.expected {
+			t.Errorf("Unexpected normalized URL for '%s'. Expected '%s' and got '%s'",
+				testItem.url, testItem.expected, normalized)
+		}
+	}
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	testData := []struct {
+		path     string
+		expected string
+	}{
+		{path: "", expected: ""},
+		{path: "/", expected: "/"},
+		{path: "/a/b/c/./../../g", expected: "/a/g"},
+		{path: "mid/content=5/../6", expected: "mid/6"},
+		{path: "/../../x", expected: "/x"},
+		{path: "/a/.", expected: "/a/"},
+		{path: "/a/b/..", expected: "/a/"},
+		{path: "/a.b/c..d", expected: "/a.b/c..d"},
+	}
+
+	for _, testItem := range testData {
+		if path := removeDotSegments(testItem.path); path != testItem.expected {
+			t.Errorf("Unexpected path for '%s'. Expected '%s' and got '%s'",
+				testItem.path, testItem.expected, path)
+		}
+	}
+}